@@ -0,0 +1,200 @@
+package main
+
+import (
+	stdcontext "context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	// acknowledgersTTL - how long chat users who have acknowledged
+	// events are remembered
+	acknowledgersTTL = 24 * time.Hour
+
+	// acknowledgeClockSkew - difference of clocks of chattixd and
+	// Zabbix which is allowed when acknowledgements are matched
+	acknowledgeClockSkew = time.Minute
+
+	// unknownAcknowledger - shown if Zabbix doesn't keep the update
+	// which has acknowledged the event
+	unknownAcknowledger = "Zabbix"
+)
+
+// keyLocks - actions with the same key are done one at a time, e.g.
+// of two simultaneous clicks of the same event the second one sees
+// that the event is already acknowledged
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mutex sync.Mutex
+	// users - number of requests which hold or wait for the lock
+	users int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		locks: map[string]*keyLock{},
+	}
+}
+
+// lock - lock the key, returned function unlocks it
+func (locks *keyLocks) lock(key string) func() {
+	locks.mutex.Lock()
+
+	lock, ok := locks.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		locks.locks[key] = lock
+	}
+
+	lock.users++
+
+	locks.mutex.Unlock()
+
+	lock.mutex.Lock()
+
+	return func() {
+		lock.mutex.Unlock()
+
+		locks.mutex.Lock()
+		defer locks.mutex.Unlock()
+
+		lock.users--
+		if lock.users == 0 {
+			delete(locks.locks, key)
+		}
+	}
+}
+
+// acknowledgers - chat users who have acknowledged events through
+// chattixd, Zabbix knows only service account if actions are not
+// done on behalf of chat users
+type acknowledgers struct {
+	mutex sync.Mutex
+	users map[string]*acknowledger
+}
+
+type acknowledger struct {
+	name string
+	time time.Time
+}
+
+func newAcknowledgers() *acknowledgers {
+	return &acknowledgers{
+		users: map[string]*acknowledger{},
+	}
+}
+
+func (acknowledgers *acknowledgers) add(eventID string, name string) {
+	acknowledgers.mutex.Lock()
+	defer acknowledgers.mutex.Unlock()
+
+	now := time.Now()
+	for key, user := range acknowledgers.users {
+		if now.Sub(user.time) > acknowledgersTTL {
+			delete(acknowledgers.users, key)
+		}
+	}
+
+	acknowledgers.users[eventID] = &acknowledger{
+		name: name,
+		time: now,
+	}
+}
+
+// get - name of chat user who has acknowledged the event not
+// earlier than since, empty if it was done outside of chattixd
+func (acknowledgers *acknowledgers) get(
+	eventID string,
+	since time.Time,
+) string {
+	acknowledgers.mutex.Lock()
+	defer acknowledgers.mutex.Unlock()
+
+	user, ok := acknowledgers.users[eventID]
+	if !ok || user.time.Before(since.Add(-acknowledgeClockSkew)) {
+		return ""
+	}
+
+	return user.name
+}
+
+// getAcknowledger - name of user who has acknowledged the event
+// first, empty if the event is not acknowledged
+func (service *actionACKService) getAcknowledger(
+	ctx stdcontext.Context,
+	eventID string,
+) (string, error) {
+	acknowledge, err := service.getZabbix().GetAcknowledge(ctx, eventID)
+	if err != nil {
+		return "", err
+	}
+
+	if acknowledge == nil {
+		return "", nil
+	}
+
+	name := service.acknowledgers.get(eventID, acknowledge.Time())
+	if name != "" {
+		return name, nil
+	}
+
+	name = acknowledge.UserName()
+	if name != "" {
+		return name, nil
+	}
+
+	return unknownAcknowledger, nil
+}
+
+// isAcknowledgement - whether Zabbix action acknowledges the event,
+// empty action is acknowledgement with message
+func isAcknowledgement(acknowledgement zabbix.Acknowledgement) bool {
+	return acknowledgement.Action == 0 ||
+		acknowledgement.Action&zabbix.ActionAcknowledge != 0
+}
+
+// withoutAcknowledge - the rest of action for already acknowledged
+// event, message is still added, so comment of the user isn't lost,
+// false is returned if nothing is left
+func withoutAcknowledge(
+	acknowledgement zabbix.Acknowledgement,
+) (zabbix.Acknowledgement, bool) {
+	action := acknowledgement.Action
+	if action == 0 {
+		action = zabbix.ActionAcknowledge | zabbix.ActionMessage
+	}
+
+	action &^= zabbix.ActionAcknowledge
+
+	if acknowledgement.Message == "" {
+		action &^= zabbix.ActionMessage
+	}
+
+	if action == 0 &&
+		!acknowledgement.Close &&
+		acknowledgement.Severity == "" {
+		return acknowledgement, false
+	}
+
+	acknowledgement.Action = action
+
+	return acknowledgement, true
+}
+
+// formatAuthorMessage - author message with name of user
+// who has done the action
+func formatAuthorMessage(config messengerConfig, name string) string {
+	return strings.Replace(
+		config.AuthorMessage,
+		usernamePlaceholder,
+		name,
+		-1,
+	)
+}
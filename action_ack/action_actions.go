@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+// Statuses of alert after configured action
+const (
+	closedStatus          = "CLOSED"
+	unacknowledgedStatus  = "UNACKNOWLEDGED"
+	suppressedStatus      = "SUPPRESSED"
+	unsuppressedStatus    = "UNSUPPRESSED"
+	severityChangedStatus = "SEVERITY CHANGED"
+	commentedStatus       = "COMMENTED"
+)
+
+// getActionStatus - status which is shown in alert title after
+// action with passed event.acknowledge bitmask
+func getActionStatus(action int) string {
+	switch {
+	case action == 0:
+		return acknowledgedStatus
+	case action&zabbix.ActionClose != 0:
+		return closedStatus
+	case action&zabbix.ActionUnacknowledge != 0:
+		return unacknowledgedStatus
+	case action&zabbix.ActionSuppress != 0:
+		return suppressedStatus
+	case action&zabbix.ActionUnsuppress != 0:
+		return unsuppressedStatus
+	case action&zabbix.ActionAcknowledge != 0:
+		return acknowledgedStatus
+	case action&zabbix.ActionChangeSeverity != 0:
+		return severityChangedStatus
+	default:
+		return commentedStatus
+	}
+}
+
+// isResolvingAction - whether alert is done after action, buttons
+// of such alert are removed, other actions keep them
+func isResolvingAction(action int) bool {
+	return action == 0 ||
+		action&(zabbix.ActionClose|zabbix.ActionAcknowledge) != 0
+}
+
+// actionSubmission - options of configured action in the same form
+// as they are filled in acknowledgement dialog
+func actionSubmission(
+	actionContext *context.ContextActionACK,
+) dialogSubmission {
+	return dialogSubmission{
+		Close:    actionContext.ZabbixAction&zabbix.ActionClose != 0,
+		Severity: actionContext.ZabbixSeverity,
+	}
+}
+
+// actionAcknowledgement - make Zabbix request for configured action,
+// message of action overrides author message
+func actionAcknowledgement(
+	actionContext *context.ContextActionACK,
+	authorMessage string,
+	username string,
+) zabbix.Acknowledgement {
+	acknowledgement := actionSubmission(actionContext).acknowledgement(
+		actionContext.EventID,
+		authorMessage,
+	)
+
+	acknowledgement.Action = actionContext.ZabbixAction
+
+	if actionContext.ZabbixMessage != "" {
+		acknowledgement.Message = strings.Replace(
+			actionContext.ZabbixMessage,
+			usernamePlaceholder,
+			username,
+			-1,
+		)
+	}
+
+	return acknowledgement
+}
+
+// parseSlackAction - decode action context from pressed Slack button
+func parseSlackAction(
+	action *chat.SlackAction,
+) (*context.ContextActionACK, error) {
+	value, _ := action.Value.(string)
+
+	return context.ParseSlackValue(action.Name, value)
+}
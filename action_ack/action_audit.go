@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	karma "github.com/reconquest/karma-go"
+)
+
+const (
+	auditPath = "/audit"
+
+	auditResultOK     = "ok"
+	auditResultError  = "error"
+	auditResultDenied = "denied"
+	// auditResultAlreadyAcknowledged - event was acknowledged by
+	// someone else, only the rest of action is done
+	auditResultAlreadyAcknowledged = "already_acknowledged"
+
+	// auditMaxLineSize - limit of one record, message of Zabbix
+	// error can be long
+	auditMaxLineSize = 1024 * 1024
+)
+
+// auditConfig - where actions of chat users are recorded,
+// nothing is recorded if path is empty
+type auditConfig struct {
+	Path string `toml:"path"`
+	// Token - bearer token of GET /audit, audit log isn't
+	// served over HTTP if it's empty
+	Token string `toml:"token"`
+}
+
+// auditRecord - action of chat user with alert or host
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Messenger string    `json:"messenger"`
+	UserID    string    `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	UserLogin string    `json:"user_login,omitempty"`
+	EventID   string    `json:"event_id"`
+	// Host - host of action which isn't done with alert, e.g. silence
+	Host         string `json:"host,omitempty"`
+	Action       string `json:"action"`
+	ZabbixAction int    `json:"zabbix_action,omitempty"`
+	Channel      string `json:"channel"`
+	LatencyMS    int64  `json:"latency_ms"`
+	Result       string `json:"result"`
+	Error        string `json:"error,omitempty"`
+}
+
+// auditFilter - which records are returned by query,
+// empty fields match everything
+type auditFilter struct {
+	EventID string
+	// User - ID, login or name of chat user
+	User    string
+	Channel string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (filter auditFilter) matches(record *auditRecord) bool {
+	if filter.EventID != "" && record.EventID != filter.EventID {
+		return false
+	}
+
+	if filter.User != "" &&
+		record.UserID != filter.User &&
+		!strings.EqualFold(record.UserLogin, filter.User) &&
+		!strings.EqualFold(record.UserName, filter.User) {
+		return false
+	}
+
+	if filter.Channel != "" &&
+		!strings.EqualFold(
+			strings.TrimPrefix(record.Channel, "#"),
+			strings.TrimPrefix(filter.Channel, "#"),
+		) {
+		return false
+	}
+
+	if !filter.Since.IsZero() && record.Time.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && record.Time.After(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+// auditLog - append-only JSONL file with actions of chat users
+type auditLog struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return &auditLog{}, nil
+	}
+
+	file, err := os.OpenFile(
+		path,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0640,
+	)
+	if err != nil {
+		return nil, karma.Describe(
+			"path", path,
+		).Format(err, "can't open audit log")
+	}
+
+	return &auditLog{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// write - append record to audit log, every record is written
+// by single write call, so lines aren't mixed
+func (audit *auditLog) write(record *auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	if audit.file == nil {
+		return nil
+	}
+
+	_, err = audit.file.Write(append(line, '\n'))
+	if err != nil {
+		return karma.Describe(
+			"path", audit.path,
+		).Format(err, "can't write audit record")
+	}
+
+	return nil
+}
+
+// reopen - write records to file at passed path from now on, file
+// at the previous path is closed, empty path disables audit log
+func (audit *auditLog) reopen(path string) error {
+	reopened, err := newAuditLog(path)
+	if err != nil {
+		return err
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	previous := audit.file
+
+	audit.path = reopened.path
+	audit.file = reopened.file
+
+	if previous == nil {
+		return nil
+	}
+
+	return previous.Close()
+}
+
+func (audit *auditLog) close() error {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	if audit.file == nil {
+		return nil
+	}
+
+	return audit.file.Close()
+}
+
+// queryAuditLog - return records of audit log file which match filter
+func queryAuditLog(path string, filter auditFilter) ([]*auditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, karma.Describe(
+			"path", path,
+		).Format(err, "can't open audit log")
+	}
+
+	defer file.Close()
+
+	return readAuditRecords(file, filter)
+}
+
+func readAuditRecords(
+	reader io.Reader,
+	filter auditFilter,
+) ([]*auditRecord, error) {
+	records := []*auditRecord{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), auditMaxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := &auditRecord{}
+
+		err := json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return nil, karma.Describe(
+				"line", line,
+			).Format(err, "can't decode audit record")
+		}
+
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// parseAuditTime - parse time in RFC3339 format or duration
+// which is counted back from now, e.g. "24h"
+func parseAuditTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return now.Add(-duration), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// audit - record action of chat user, error of audit log
+// doesn't fail the action. Every action is counted in metrics
+// by its result.
+func (service *actionACKService) audit(record *auditRecord) {
+	ackRequests.WithLabelValues(record.Messenger, record.Result).Inc()
+
+	err := service.auditLog.write(record)
+	if err != nil {
+		service.logger.Error(err)
+	}
+}
+
+// handleAudit - return records of audit log as JSONL,
+// records are filtered by event, user, channel, since and until
+func (service *actionACKService) handleAudit(context *gin.Context) {
+	destiny := karma.Describe(
+		"method", "handleAudit",
+	)
+
+	config := service.getConfig()
+
+	// route is always served, so audit log can be enabled by reload
+	if config.Audit.Path == "" || config.Audit.Token == "" {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if !hasBearerToken(context, config.Audit.Token) {
+		context.Status(http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+
+	since, err := parseAuditTime(context.Query("since"), now)
+	if err != nil {
+		context.String(http.StatusBadRequest, "invalid since: %s", err)
+		return
+	}
+
+	until, err := parseAuditTime(context.Query("until"), now)
+	if err != nil {
+		context.String(http.StatusBadRequest, "invalid until: %s", err)
+		return
+	}
+
+	records, err := queryAuditLog(
+		config.Audit.Path,
+		auditFilter{
+			EventID: context.Query("event"),
+			User:    context.Query("user"),
+			Channel: context.Query("channel"),
+			Since:   since,
+			Until:   until,
+		},
+	)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't query audit log",
+			),
+		)
+		return
+	}
+
+	context.Status(http.StatusOK)
+	context.Header("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(context.Writer)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			service.logger.Error(err)
+			return
+		}
+	}
+}
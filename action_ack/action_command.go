@@ -0,0 +1,685 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/gin-gonic/gin"
+	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	commandProblems = "problems"
+	commandAck      = "ack"
+	commandHost     = "host"
+	commandSilence  = "silence"
+
+	commandResponseEphemeral = "ephemeral"
+	commandResponseInChannel = "in_channel"
+
+	commandUsage = "Usage:\n" +
+		"`/zabbix problems [host|group]` - show the latest problems\n" +
+		"`/zabbix ack <eventid> <comment>` - acknowledge event\n" +
+		"`/zabbix host <name>` - show host status and groups\n" +
+		"`/zabbix silence <host> <duration>` - put host into maintenance"
+
+	problemSeverity = "PROBLEM"
+)
+
+// slashCommand - slash command request, Slack and Mattermost
+// send the same form fields
+type slashCommand struct {
+	Token       string `form:"token"`
+	ChannelID   string `form:"channel_id"`
+	ChannelName string `form:"channel_name"`
+	UserID      string `form:"user_id"`
+	UserName    string `form:"user_name"`
+	Command     string `form:"command"`
+	Text        string `form:"text"`
+	// Messenger - chat which has sent the command
+	Messenger string `form:"-"`
+}
+
+// commandResponse - answer to slash command which is
+// understood by Slack and Mattermost
+type commandResponse struct {
+	ResponseType string      `json:"response_type"`
+	Text         string      `json:"text"`
+	Attachments  interface{} `json:"attachments,omitempty"`
+}
+
+func (service *actionACKService) handleCommandSlack(context *gin.Context) {
+	service.handleCommand(context, messengerSlack)
+}
+
+func (service *actionACKService) handleCommandMattermost(
+	context *gin.Context,
+) {
+	service.handleCommand(context, messengerMattermost)
+}
+
+func (service *actionACKService) handleCommand(
+	context *gin.Context,
+	messenger string,
+) {
+	destiny := karma.Describe(
+		"method", "handleCommand",
+	).Describe(
+		"messenger", messenger,
+	)
+
+	var command slashCommand
+
+	err := context.ShouldBind(&command)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't parse slash command",
+			),
+		)
+		return
+	}
+
+	command.Messenger = messenger
+
+	messengerConfig := service.getConfig().Messenger[messenger]
+
+	if !messengerConfig.verifiesCommands(messenger) {
+		service.logger.Warning(
+			destiny.Reason(
+				"slash command is rejected, command_token is required " +
+					"unless insecure_skip_verify is set",
+			),
+		)
+
+		context.JSON(http.StatusForbidden, commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Command token is not configured",
+		})
+		return
+	}
+
+	if messengerConfig.CommandToken != "" &&
+		subtle.ConstantTimeCompare(
+			[]byte(command.Token),
+			[]byte(messengerConfig.CommandToken),
+		) != 1 {
+		context.JSON(http.StatusUnauthorized, commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Invalid command token",
+		})
+		return
+	}
+
+	args := strings.Fields(command.Text)
+	if len(args) == 0 {
+		context.JSON(http.StatusOK, commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         commandUsage,
+		})
+		return
+	}
+
+	destiny = destiny.Describe(
+		"command", command.Text,
+	).Describe(
+		"user", command.UserID,
+	)
+
+	var response *commandResponse
+
+	switch args[0] {
+	case commandProblems:
+		response, err = service.commandProblems(context, &command, args[1:])
+	case commandAck:
+		response, err = service.commandAck(context, &command, args[1:])
+	case commandHost:
+		response, err = service.commandHost(context, &command, args[1:])
+	case commandSilence:
+		response, err = service.commandSilence(context, &command, args[1:])
+	default:
+		response = &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         commandUsage,
+		}
+	}
+
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't execute slash command",
+			),
+		)
+
+		context.JSON(http.StatusOK, commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Can't execute command, see chattixd logs",
+		})
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+func (service *actionACKService) commandProblems(
+	context *gin.Context,
+	command *slashCommand,
+	args []string,
+) (*commandResponse, error) {
+	ctx := context.Request.Context()
+
+	filter := zabbix.ProblemFilter{}
+	title := "Problems"
+
+	if len(args) > 0 {
+		name := strings.Join(args, " ")
+		title = fmt.Sprintf("Problems of %s", name)
+
+		hosts, err := service.getZabbix().FindHosts(
+			ctx,
+			name,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range hosts {
+			filter.HostIDs = append(filter.HostIDs, host.HostID)
+		}
+
+		if len(filter.HostIDs) == 0 {
+			groups, err := service.getZabbix().FindHostGroups(
+				ctx,
+				name,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, group := range groups {
+				filter.GroupIDs = append(filter.GroupIDs, group.GroupID)
+			}
+		}
+
+		if len(filter.HostIDs) == 0 && len(filter.GroupIDs) == 0 {
+			return &commandResponse{
+				ResponseType: commandResponseEphemeral,
+				Text: fmt.Sprintf(
+					"Host or host group %s is not found",
+					name,
+				),
+			}, nil
+		}
+	}
+
+	problems, err := service.getZabbix().GetProblems(
+		ctx,
+		filter,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.problemsResponse(command, title, problems), nil
+}
+
+func (service *actionACKService) commandAck(
+	context *gin.Context,
+	command *slashCommand,
+	args []string,
+) (*commandResponse, error) {
+	if len(args) < 1 {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Usage: `/zabbix ack <eventid> <comment>`",
+		}, nil
+	}
+
+	eventID := args[0]
+	comment := strings.Join(args[1:], " ")
+
+	user, err := service.fetchUser(context, command)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.authorize(
+		context.Request.Context(),
+		user,
+		command.ChannelName,
+		defaultAction,
+		eventID,
+	)
+	if err != nil {
+		if !karma.Contains(err, errActionNotAllowed) {
+			return nil, err
+		}
+
+		service.logger.Warning(err)
+
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         notAllowedText,
+		}, nil
+	}
+
+	authorMessage := strings.Replace(
+		service.getConfig().Messenger[command.Messenger].AuthorMessage,
+		usernamePlaceholder,
+		user.Name,
+		-1,
+	)
+
+	submission := dialogSubmission{Comment: comment}
+
+	acknowledgedBy, err := service.acknowledge(
+		context.Request.Context(),
+		user,
+		command.ChannelName,
+		defaultAction,
+		submission.acknowledgement(eventID, authorMessage),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf(
+		"Event %s: %s",
+		eventID,
+		formatAuthorMessage(
+			service.getConfig().Messenger[command.Messenger],
+			acknowledgedBy,
+		),
+	)
+	if comment != "" {
+		text = fmt.Sprintf("%s\n> %s", text, comment)
+	}
+
+	return &commandResponse{
+		ResponseType: commandResponseInChannel,
+		Text:         text,
+	}, nil
+}
+
+func (service *actionACKService) commandHost(
+	context *gin.Context,
+	command *slashCommand,
+	args []string,
+) (*commandResponse, error) {
+	if len(args) < 1 {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Usage: `/zabbix host <name>`",
+		}, nil
+	}
+
+	name := strings.Join(args, " ")
+
+	hosts, err := service.getZabbix().FindHosts(
+		context.Request.Context(),
+		name,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         fmt.Sprintf("Host %s is not found", name),
+		}, nil
+	}
+
+	message := newCommandMessage(command.Messenger)
+
+	for _, host := range hosts {
+		status := "Enabled"
+		if host.Status != "0" {
+			status = "Disabled"
+		}
+
+		attachment := message.CreateAttachment("", "")
+		attachment.SetTitle(host.Name)
+		attachment.AddField(true, "Host", host.Host)
+		attachment.AddField(true, "Status", status)
+
+		addresses := []string{}
+		for _, hostInterface := range host.Interfaces {
+			address := hostInterface.IP
+			if hostInterface.DNS != "" {
+				address = hostInterface.DNS
+			}
+
+			addresses = append(addresses, address)
+		}
+
+		if len(addresses) > 0 {
+			attachment.AddField(
+				true,
+				"Interfaces",
+				strings.Join(addresses, ", "),
+			)
+		}
+
+		groups := []string{}
+		for _, group := range host.Groups {
+			groups = append(groups, group.Name)
+		}
+
+		if len(groups) > 0 {
+			attachment.AddField(true, "Groups", strings.Join(groups, ", "))
+		}
+	}
+
+	return service.commandResponse(
+		commandResponseEphemeral,
+		fmt.Sprintf("Hosts matching %s", name),
+		message,
+	), nil
+}
+
+func (service *actionACKService) commandSilence(
+	context *gin.Context,
+	command *slashCommand,
+	args []string,
+) (*commandResponse, error) {
+	if len(args) < 2 {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "Usage: `/zabbix silence <host> <duration>`",
+		}, nil
+	}
+
+	name := strings.Join(args[:len(args)-1], " ")
+
+	duration, err := parseSilenceDuration(args[len(args)-1])
+	if err != nil {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text: fmt.Sprintf(
+				"Invalid duration %s, use e.g. 30m, 2h or 1d",
+				args[len(args)-1],
+			),
+		}, nil
+	}
+
+	// silence is too dangerous to be applied to several hosts
+	// which are matched by the name, so only exact name is used
+	host, err := service.getZabbix().GetHost(
+		context.Request.Context(),
+		name,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == nil {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         fmt.Sprintf("Host %s is not found", name),
+		}, nil
+	}
+
+	user, err := service.fetchUser(context, command)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.authorizeHost(
+		context.Request.Context(),
+		user,
+		command.ChannelName,
+		commandSilence,
+		host,
+	)
+	if err != nil {
+		if !karma.Contains(err, errActionNotAllowed) {
+			return nil, err
+		}
+
+		service.logger.Warning(err)
+
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         notAllowedText,
+		}, nil
+	}
+
+	started := time.Now()
+
+	// maintenance is created on behalf of the chat user as
+	// acknowledgements are
+	client, err := service.getUsers().client(context.Request.Context(), user)
+	if err == nil {
+		err = client.CreateMaintenance(
+			context.Request.Context(),
+			host,
+			duration,
+			fmt.Sprintf("Created from chat by %s", user.Name),
+		)
+	}
+
+	record := &auditRecord{
+		Time:      started,
+		Messenger: user.Messenger,
+		UserID:    user.ID,
+		UserName:  user.Name,
+		UserLogin: user.Login,
+		Host:      host.Host,
+		Action:    commandSilence,
+		Channel:   command.ChannelName,
+		LatencyMS: time.Since(started).Milliseconds(),
+		Result:    auditResultOK,
+	}
+
+	if err != nil {
+		record.Result = auditResultError
+		record.Error = secrets.Redact(err.Error())
+	}
+
+	service.audit(record)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &commandResponse{
+		ResponseType: commandResponseInChannel,
+		Text: fmt.Sprintf(
+			"Host %s is silenced for %s by %s",
+			host.Name,
+			duration,
+			user.Name,
+		),
+	}, nil
+}
+
+// problemsResponse - format problems as attachments with
+// ACK buttons for not acknowledged problems
+func (service *actionACKService) problemsResponse(
+	command *slashCommand,
+	title string,
+	problems []*zabbix.Problem,
+) *commandResponse {
+	if len(problems) == 0 {
+		return &commandResponse{
+			ResponseType: commandResponseEphemeral,
+			Text:         "No problems",
+		}
+	}
+
+	messengerConfig := service.getConfig().Messenger[command.Messenger]
+
+	message := newCommandMessage(command.Messenger)
+
+	for _, problem := range problems {
+		hosts := []string{}
+		for _, host := range problem.Hosts {
+			hosts = append(hosts, host.Name)
+		}
+
+		text := problem.Name
+		if len(hosts) > 0 {
+			text = fmt.Sprintf("%s: %s", strings.Join(hosts, ", "), text)
+		}
+
+		color := ""
+		if problem.Acknowledged == "1" {
+			color = messengerConfig.AttachmentsColor
+		}
+
+		attachment := message.CreateAttachment(text, color)
+		attachment.SetTitle(getZabbixSeverityName(problem.Severity))
+		attachment.AddField(true, "Event ID", problem.EventID)
+
+		if clock, err := strconv.ParseInt(problem.Clock, 10, 64); err == nil {
+			attachment.AddField(
+				true,
+				"Since",
+				time.Unix(clock, 0).Format("2006-01-02 15:04:05"),
+			)
+		}
+
+		if problem.Acknowledged == "1" {
+			attachment.AddField(true, "Status", acknowledgedStatus)
+			continue
+		}
+
+		service.addCommandAction(command, attachment, problem.EventID, text)
+	}
+
+	return service.commandResponse(commandResponseInChannel, title, message)
+}
+
+// addCommandAction - add ACK button which is handled by the same
+// handler as buttons of webhook messages
+func (service *actionACKService) addCommandAction(
+	command *slashCommand,
+	attachment chat.MessageAttachment,
+	eventID string,
+	text string,
+) {
+	messengerConfig := service.getConfig().Messenger[command.Messenger]
+
+	switch command.Messenger {
+	case messengerSlack:
+		attachment.AddAction(
+			defaultAction,
+			defaultAction,
+			defaultActionType,
+			eventID,
+		)
+
+	case messengerMattermost:
+		actionContext := context.ContextActionACK{
+			EventID:  eventID,
+			Action:   defaultAction,
+			Severity: problemSeverity,
+			Message:  text,
+			Channel:  command.ChannelName,
+		}
+
+		service.signActionContext(messengerMattermost, &actionContext)
+
+		attachment.AddAction(
+			defaultAction,
+			messengerConfig.PublicURL+mattermostPath+actionsPath,
+			defaultActionType,
+			structs.Map(actionContext),
+		)
+	}
+}
+
+func newCommandMessage(messenger string) chat.Message {
+	if messenger == messengerSlack {
+		return chat.NewSlackMessage()
+	}
+
+	return chat.NewMattermostMessage()
+}
+
+func (service *actionACKService) commandResponse(
+	responseType string,
+	text string,
+	message chat.Message,
+) *commandResponse {
+	response := &commandResponse{
+		ResponseType: responseType,
+		Text:         text,
+	}
+
+	switch message := message.(type) {
+	case *chat.SlackMessage:
+		response.Attachments = message.Attachments
+	case *chat.MattermostMessage:
+		response.Attachments = message.Attachments
+	}
+
+	return response
+}
+
+// fetchUser - fetch chat user who called command
+func (service *actionACKService) fetchUser(
+	context *gin.Context,
+	command *slashCommand,
+) (*chatUser, error) {
+	messengerConfig := service.getConfig().Messenger[command.Messenger]
+
+	if command.Messenger == messengerSlack {
+		return fetchUserFromSlack(
+			context.Request.Context(),
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			command.UserID,
+		)
+	}
+
+	return fetchUserFromMattermost(
+		context.Request.Context(),
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		command.UserID,
+	)
+}
+
+// parseSilenceDuration - parse duration in format of
+// time.ParseDuration with additional days suffix
+func parseSilenceDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+
+		if days <= 0 {
+			return 0, fmt.Errorf("duration should be positive")
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration should be positive")
+	}
+
+	return duration, nil
+}
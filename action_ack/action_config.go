@@ -3,6 +3,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+
+	"github.com/kovetskiy/toml"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+	"github.com/zarplata/chattix/zabbix"
 )
 
 var defaultConfiguration = `
@@ -13,6 +20,9 @@ listen_address = "0.0.0.0:5666"
 zabbix_api_url = "http://localhost/api_jsonrpc.php"
 zabbix_api_token = "token"
 
+[http]
+timeout = "30s"
+
 [messenger]
     [messenger.mattermost]
     messenger_api_token = "secret_user_token"
@@ -20,6 +30,8 @@ zabbix_api_token = "token"
     attachments_color = "#000000"
     author_message = "Acknowledged by {{USERNAME}}"
     author_image_url = "http://localhost/image"
+    ack_dialog = false
+    public_url = "http://localhost:5666"
 
     [messenger.slack]
     messenger_api_token = "secret_user_token"
@@ -27,17 +39,143 @@ zabbix_api_token = "token"
     attachments_color = "#000000"
     author_message = "Acknowledged by {{USERNAME}}"
     author_image_url = "http://localhost/image"
+    ack_dialog = false
+
+    [messenger.matrix]
+    messenger_api_token = "secret_user_token"
+    messenger_api_url = "https://matrix.example.org"
+    attachments_color = "#000000"
+    author_message = "Acknowledged by {{USERNAME}}"
+    author_image_url = "http://localhost/image"
 `
 
 type config struct {
-	ListenAddress string                     `toml:"listen_address"`
-	Zabbix        zabbixConfig               `toml:"zabbix"`
-	Messenger     map[string]messengerConfig `toml:"messenger"`
+	ListenAddress string `toml:"listen_address"`
+	// WatchConfig - reload config when its file is changed,
+	// it's reloaded on SIGHUP anyway
+	WatchConfig bool `toml:"watch_config"`
+	// Messengers - chats which are served by chattixd, messenger
+	// which chattixd is built for is served if it's empty
+	Messengers []string                   `toml:"messengers"`
+	Server     serverConfig               `toml:"server"`
+	Zabbix     zabbix.Config              `toml:"zabbix"`
+	Messenger  map[string]messengerConfig `toml:"messenger"`
+	HTTP       transport.Config           `toml:"http"`
+	Users      usersConfig                `toml:"users"`
+	Policy     policyConfig               `toml:"policy"`
+	Audit      auditConfig                `toml:"audit"`
+	Events     eventsConfig               `toml:"events"`
+	// Escalation - escalations of problems by name of severity
+	Escalation map[string]escalationConfig `toml:"escalation"`
+}
+
+// loadConfig - read config file and resolve ${ENV} and file:
+// references in its values, CHATTIX_* environment variables take
+// precedence over the file
+func loadConfig(path string) (*config, error) {
+	config := &config{}
+
+	_, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return nil, karma.Format(err, "can't read config file %s", path)
+	}
+
+	err = secrets.Resolve(config)
+	if err != nil {
+		return nil, karma.Format(err, "can't resolve config file %s", path)
+	}
+
+	parseEnvironmentVariables(config)
+
+	return config, nil
+}
+
+// validate - check settings which are not checked by
+// clients created from config
+func (config *config) validate() error {
+	for _, messenger := range config.getMessengers() {
+		switch messenger {
+		case messengerSlack, messengerMattermost, messengerMatrix:
+		default:
+			return karma.Describe(
+				"messenger", messenger,
+			).Reason("unknown messenger")
+		}
+
+		messengerConfig, ok := config.Messenger[messenger]
+		if !ok {
+			return karma.Describe(
+				"messenger", messenger,
+			).Reason("messenger section is not found in config")
+		}
+
+		err := messengerConfig.validateVerification(messenger)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch config.Policy.Default {
+	case "", policyAllow, policyDeny:
+	default:
+		return karma.Describe(
+			"default", config.Policy.Default,
+		).Reason("policy default should be allow or deny")
+	}
+
+	switch config.Users.MatchBy {
+	case "", matchUsersByEmail, matchUsersByLogin:
+	default:
+		return karma.Describe(
+			"match_by", config.Users.MatchBy,
+		).Reason("users should be matched by email or login")
+	}
+
+	_, err := transport.NewClient(config.HTTP)
+	if err != nil {
+		return karma.Format(err, "invalid http section")
+	}
+
+	_, err = config.Events.getCheckInterval()
+	if err != nil {
+		return karma.Format(err, "invalid events section")
+	}
+
+	_, err = config.Events.getTTL()
+	if err != nil {
+		return karma.Format(err, "invalid events section")
+	}
+
+	err = config.validateEscalation()
+	if err != nil {
+		return karma.Format(err, "invalid escalation section")
+	}
+
+	return nil
+}
+
+// getMessengers - chats which are served by chattixd
+func (config *config) getMessengers() []string {
+	if len(config.Messengers) == 0 {
+		return []string{definedMessenger}
+	}
+
+	return config.Messengers
 }
 
-type zabbixConfig struct {
-	ZabbixAPIURL   string `toml:"zabbix_api_url"`
-	ZabbixAPIToken string `toml:"zabbix_api_token"`
+// usersConfig - how chat users are mapped to Zabbix users,
+// actions are done by service account if nothing is set
+type usersConfig struct {
+	// MatchBy - look up Zabbix user by "email" or "login" of chat user
+	MatchBy string `toml:"match_by"`
+	// MappingFile - static mapping of chat users to Zabbix users
+	MappingFile string `toml:"mapping_file"`
+	// Impersonate - create API tokens of Zabbix users by service account
+	Impersonate bool   `toml:"impersonate"`
+	TokenTTL    string `toml:"token_ttl"`
+	// RequireMapping - reject action if it can't be done on behalf
+	// of the chat user instead of doing it by service account
+	RequireMapping bool `toml:"require_mapping"`
 }
 
 type messengerConfig struct {
@@ -46,6 +184,74 @@ type messengerConfig struct {
 	AttachmentsColor  string `toml:"attachments_color"`
 	AuthorMessage     string `toml:"author_message"`
 	AuthorImageURL    string `toml:"author_image_url"`
+	AckDialog         bool   `toml:"ack_dialog"`
+	PublicURL         string `toml:"public_url"`
+	CommandToken      string `toml:"command_token"`
+	SigningSecret     string `toml:"signing_secret"`
+	ActionSecret      string `toml:"action_secret"`
+	// InsecureSkipVerify - accept requests of chat which can't be
+	// verified because secret is not set
+	InsecureSkipVerify bool `toml:"insecure_skip_verify"`
+}
+
+// validateVerification - requests of chat are accepted only if they
+// can be verified, checks are skipped only if it's explicitly allowed
+func (config messengerConfig) validateVerification(messenger string) error {
+	missing := ""
+	variable := ""
+
+	switch messenger {
+	case messengerSlack:
+		if config.SigningSecret == "" {
+			missing = "signing_secret"
+			variable = "CHATTIX_MESSENGER_SIGNING_SECRET"
+		}
+	// anybody who can reach chattixd or react in the room can pass
+	// any context, so only signature tells it's made by webhook
+	case messengerMattermost, messengerMatrix:
+		if config.ActionSecret == "" {
+			missing = "action_secret"
+			variable = "CHATTIX_MESSENGER_ACTION_SECRET"
+		}
+	}
+
+	if missing == "" {
+		return nil
+	}
+
+	// default configuration, which is used without config file,
+	// has no secrets, so they are usually passed by environment
+	if !config.InsecureSkipVerify {
+		return karma.Describe(
+			"messenger", messenger,
+		).Reason(
+			fmt.Sprintf(
+				"%s is required, set it in config or %s, or set "+
+					"insecure_skip_verify in config or "+
+					"CHATTIX_MESSENGER_INSECURE_SKIP_VERIFY=true "+
+					"to accept requests which can't be verified",
+				missing,
+				variable,
+			),
+		)
+	}
+
+	logger.Warningf(
+		"%s of %s is not set, its requests are not verified",
+		missing,
+		messenger,
+	)
+
+	return nil
+}
+
+// verifiesCommands - whether slash commands of the chat can be
+// verified, otherwise anybody who can reach chattixd could run them
+// on behalf of any user. Slack commands are verified by signature.
+func (config messengerConfig) verifiesCommands(messenger string) bool {
+	return config.CommandToken != "" ||
+		(messenger == messengerSlack && config.SigningSecret != "") ||
+		config.InsecureSkipVerify
 }
 
 func parseEnvironmentVariables(
@@ -59,19 +265,19 @@ func parseEnvironmentVariables(
 	}
 
 	if value := os.Getenv("CHATTIX_ZABBIX_URL"); value != "" {
-		config.Zabbix.ZabbixAPIURL = value
+		config.Zabbix.URL = value
 	}
 
 	if value := os.Getenv("CHATTIX_ZABBIX_TOKEN"); value != "" {
-		config.Zabbix.ZabbixAPIToken = value
+		config.Zabbix.Token = value
 	}
 
 	if value := os.Getenv("CHATTIX_ZABBIX_USER"); value != "" {
-		//
+		config.Zabbix.User = value
 	}
 
 	if value := os.Getenv("CHATTIX_ZABBIX_PASSWORD"); value != "" {
-		//
+		config.Zabbix.Password = value
 	}
 
 	if value := os.Getenv("CHATTIX_MESSENGER_API_TOKEN"); value != "" {
@@ -113,4 +319,35 @@ func parseEnvironmentVariables(
 		config.Messenger[definedMessenger] = messengerConfig
 
 	}
+
+	if value := os.Getenv("CHATTIX_MESSENGER_SIGNING_SECRET"); value != "" {
+		messengerConfig := config.Messenger[definedMessenger]
+		messengerConfig.SigningSecret = value
+
+		config.Messenger[definedMessenger] = messengerConfig
+
+	}
+
+	if value := os.Getenv("CHATTIX_MESSENGER_ACTION_SECRET"); value != "" {
+		messengerConfig := config.Messenger[definedMessenger]
+		messengerConfig.ActionSecret = value
+
+		config.Messenger[definedMessenger] = messengerConfig
+
+	}
+
+	if value := os.Getenv("CHATTIX_MESSENGER_COMMAND_TOKEN"); value != "" {
+		messengerConfig := config.Messenger[definedMessenger]
+		messengerConfig.CommandToken = value
+
+		config.Messenger[definedMessenger] = messengerConfig
+	}
+
+	value := os.Getenv("CHATTIX_MESSENGER_INSECURE_SKIP_VERIFY")
+	if skip, err := strconv.ParseBool(value); err == nil {
+		messengerConfig := config.Messenger[definedMessenger]
+		messengerConfig.InsecureSkipVerify = skip
+
+		config.Messenger[definedMessenger] = messengerConfig
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	dialogCallbackID    = "ack"
+	dialogTitle         = "Acknowledge event"
+	dialogSubmitLabel   = "ACK"
+	dialogCommentField  = "comment"
+	dialogCloseField    = "close"
+	dialogSeverityField = "severity"
+
+	// dialogTTL - how long opened dialog can be submitted
+	dialogTTL = time.Hour
+)
+
+// zabbixSeverity - severity of Zabbix trigger which can be
+// chosen in acknowledgement dialog
+type zabbixSeverity struct {
+	Value string
+	Name  string
+}
+
+var zabbixSeverities = []zabbixSeverity{
+	{"0", "Not classified"},
+	{"1", "Information"},
+	{"2", "Warning"},
+	{"3", "Average"},
+	{"4", "High"},
+	{"5", "Disaster"},
+}
+
+// dialogSubmission - values which were filled by user
+// in acknowledgement dialog
+type dialogSubmission struct {
+	Comment  string
+	Close    bool
+	Severity string
+}
+
+func getZabbixSeverityName(value string) string {
+	for _, severity := range zabbixSeverities {
+		if severity.Value == value {
+			return severity.Name
+		}
+	}
+
+	return value
+}
+
+// acknowledgement - make acknowledgement of Zabbix event from
+// dialog submission, author message is used if comment is empty
+func (submission dialogSubmission) acknowledgement(
+	eventID string,
+	authorMessage string,
+) zabbix.Acknowledgement {
+	message := authorMessage
+	if submission.Comment != "" {
+		message = fmt.Sprintf("%s: %s", authorMessage, submission.Comment)
+	}
+
+	return zabbix.Acknowledgement{
+		EventID:  eventID,
+		Message:  message,
+		Close:    submission.Close,
+		Severity: submission.Severity,
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	karma "github.com/reconquest/karma-go"
+)
+
+const (
+	// correlationIDHeader - header with ID of the failed request,
+	// the same ID is logged with details of the error
+	correlationIDHeader = "X-Correlation-ID"
+
+	internalServerErrorText = "internal server error"
+)
+
+// internalServerError - answer to the chat when request is failed,
+// details of the error are only logged because they contain URLs
+// and identifiers which shouldn't be shown to chat users
+type internalServerError struct {
+	Error         string `json:"error"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// sendInternalServerError - log the error with correlation ID and
+// answer with this ID, so the answer can be matched with the log
+// record without revealing details of the error
+func (service *actionACKService) sendInternalServerError(
+	context *gin.Context,
+	err error,
+) {
+	correlationID := newCorrelationID()
+
+	service.logger.Error(
+		karma.Describe(
+			"correlation id", correlationID,
+		).Reason(err),
+	)
+
+	context.Header(correlationIDHeader, correlationID)
+	context.JSON(http.StatusInternalServerError, internalServerError{
+		Error:         internalServerErrorText,
+		CorrelationID: correlationID,
+	})
+}
+
+func newCorrelationID() string {
+	id := make([]byte, 8)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		// ID only links answer with log record, so it's not
+		// a reason to fail
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	stdcontext "context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	escalationTitle = "NOT ACKNOWLEDGED"
+
+	// eventsCheckTimeout - limit of one check of tracked
+	// events including escalations
+	eventsCheckTimeout = time.Minute
+)
+
+// mattermostChannelID - channels are posted to by ID through
+// Mattermost API
+var mattermostChannelID = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+// escalationConfig - what is done when problem of the severity
+// isn't acknowledged in time
+type escalationConfig struct {
+	Timeout string `toml:"timeout"`
+	// Mention - louder mention in reply to the alert, e.g. @channel,
+	// alert is posted again if chat doesn't tell where it was posted
+	Mention string `toml:"mention"`
+	// Channel - escalation channel of the same chat
+	Channel string `toml:"channel"`
+	// Messenger - other chat where alert is posted to
+	// MessengerChannel
+	Messenger        string `toml:"messenger"`
+	MessengerChannel string `toml:"messenger_channel"`
+}
+
+func (config escalationConfig) getTimeout() (time.Duration, error) {
+	return parseServerDuration("timeout", config.Timeout, 0)
+}
+
+// getEscalation - escalation of Zabbix severity, severities
+// are named as in Zabbix, case doesn't matter
+func (config *config) getEscalation(
+	severity string,
+) (escalationConfig, bool) {
+	name := getZabbixSeverityName(severity)
+
+	for key, escalation := range config.Escalation {
+		if strings.EqualFold(key, name) {
+			return escalation, true
+		}
+	}
+
+	return escalationConfig{}, false
+}
+
+// validateEscalation - check escalations, unknown severity or
+// messenger would be noticed only when problem isn't acknowledged
+func (config *config) validateEscalation() error {
+	for severity, escalation := range config.Escalation {
+		destiny := karma.Describe(
+			"severity", severity,
+		)
+
+		known := false
+		for _, zabbixSeverity := range zabbixSeverities {
+			if strings.EqualFold(severity, zabbixSeverity.Name) {
+				known = true
+			}
+		}
+
+		if !known {
+			return destiny.Reason("unknown severity of escalation")
+		}
+
+		timeout, err := escalation.getTimeout()
+		if err != nil {
+			return destiny.Reason(err)
+		}
+
+		if timeout <= 0 {
+			return destiny.Reason("timeout of escalation is required")
+		}
+
+		if mattermost, ok := config.Messenger[messengerMattermost]; ok &&
+			escalation.Channel != "" {
+			err := validateMattermostChannel(mattermost, escalation.Channel)
+			if err != nil {
+				return destiny.Reason(err)
+			}
+		}
+
+		if escalation.Messenger == "" {
+			continue
+		}
+
+		if _, ok := config.Messenger[escalation.Messenger]; !ok {
+			return destiny.Describe(
+				"messenger", escalation.Messenger,
+			).Reason("messenger section is not found in config")
+		}
+
+		if escalation.MessengerChannel == "" {
+			return destiny.Reason("messenger_channel is required")
+		}
+
+		if escalation.Messenger == messengerMattermost {
+			err := validateMattermostChannel(
+				config.Messenger[messengerMattermost],
+				escalation.MessengerChannel,
+			)
+			if err != nil {
+				return destiny.Reason(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateMattermostChannel - Mattermost API accepts channel only
+// by ID, team/channel name is resolved into ID before posting,
+// incoming webhooks accept name of channel
+func validateMattermostChannel(
+	messengerConfig messengerConfig,
+	channel string,
+) error {
+	if messengerConfig.MessengerAPIToken == "" ||
+		!chat.IsMattermostAPIURL(messengerConfig.MessengerAPIURL) ||
+		mattermostChannelID.MatchString(channel) {
+		return nil
+	}
+
+	parts := strings.SplitN(channel, "/", 2)
+	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		return nil
+	}
+
+	return karma.Describe(
+		"channel", channel,
+	).Reason("Mattermost channel should be set by ID or as team/channel")
+}
+
+// watchEvents - check tracked events in Zabbix periodically, posts
+// are synced with changes made in Zabbix, events which are resolved
+// are forgotten, others are escalated when timeout of their severity
+// is passed
+func (service *actionACKService) watchEvents(ctx stdcontext.Context) {
+	for ctx.Err() == nil {
+		// config is read on every check, so reloaded
+		// escalations are used by the next one
+		interval, err := service.getConfig().Events.getCheckInterval()
+		if err != nil {
+			interval = defaultEventsCheckInterval
+		}
+
+		sleepContext(ctx, interval)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		service.checkEvents(ctx)
+	}
+}
+
+func (service *actionACKService) checkEvents(ctx stdcontext.Context) {
+	destiny := karma.Describe(
+		"method", "checkEvents",
+	)
+
+	tracked := service.events.list()
+	if len(tracked) == 0 {
+		return
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(ctx, eventsCheckTimeout)
+	defer cancel()
+
+	config := service.getConfig()
+
+	ttl, err := config.Events.getTTL()
+	if err != nil {
+		ttl = defaultEventsTTL
+	}
+
+	eventIDs := []string{}
+	seen := map[string]bool{}
+
+	for _, event := range tracked {
+		if !seen[event.EventID] {
+			seen[event.EventID] = true
+			eventIDs = append(eventIDs, event.EventID)
+		}
+	}
+
+	states, err := service.getZabbix().GetEventStates(ctx, eventIDs)
+	if err != nil {
+		service.logger.Error(
+			destiny.Format(err, "can't check tracked events"),
+		)
+		return
+	}
+
+	statesByID := map[string]*zabbix.EventState{}
+	for _, state := range states {
+		statesByID[state.EventID] = state
+	}
+
+	forgotten := []*trackedEvent{}
+
+	for _, event := range tracked {
+		state, ok := statesByID[event.EventID]
+		if !ok || time.Since(event.RegisteredAt) > ttl {
+			forgotten = append(forgotten, event)
+			continue
+		}
+
+		if config.Events.Sync {
+			if service.syncEvent(ctx, event, state) {
+				forgotten = append(forgotten, event)
+				continue
+			}
+		} else if state.IsAcknowledged() || state.IsResolved() {
+			forgotten = append(forgotten, event)
+			continue
+		}
+
+		if state.IsAcknowledged() {
+			continue
+		}
+
+		if !event.EscalatedAt.IsZero() {
+			continue
+		}
+
+		escalation, ok := config.getEscalation(state.Severity)
+		if !ok {
+			continue
+		}
+
+		timeout, err := escalation.getTimeout()
+		if err != nil || time.Since(event.RegisteredAt) < timeout {
+			continue
+		}
+
+		// event is escalated once even if some of posts are
+		// failed, otherwise successful ones would be repeated
+		service.escalate(ctx, event, escalation, state.Severity)
+
+		event.EscalatedAt = time.Now()
+
+		err = service.events.update(event)
+		if err != nil {
+			service.logger.Error(err)
+		}
+	}
+
+	err = service.events.remove(forgotten)
+	if err != nil {
+		service.logger.Error(err)
+	}
+}
+
+// escalate - reply to the alert with louder mention and post it
+// to escalation channel and to other chat if they are configured
+func (service *actionACKService) escalate(
+	ctx stdcontext.Context,
+	event *trackedEvent,
+	escalation escalationConfig,
+	severity string,
+) {
+	destiny := karma.Describe(
+		"method", "escalate",
+	).Describe(
+		"eventID", event.EventID,
+	).Describe(
+		"messenger", event.Messenger,
+	).Describe(
+		"channel", event.Channel,
+	)
+
+	config := service.getConfig()
+
+	text := fmt.Sprintf(
+		"%s problem is not acknowledged for %s",
+		getZabbixSeverityName(severity),
+		time.Since(event.RegisteredAt).Round(time.Minute),
+	)
+
+	post := func(messenger string, channel string, mention string) {
+		messengerConfig := config.Messenger[messenger]
+
+		message := newEscalationMessage(
+			messenger,
+			messengerConfig,
+			event,
+			strings.TrimSpace(mention+" "+text),
+		)
+
+		var err error
+
+		switch {
+		case messenger == event.Messenger &&
+			channel == event.Channel &&
+			!event.Ref.IsEmpty():
+			_, err = message.Reply(
+				ctx,
+				messengerConfig.MessengerAPIURL,
+				messengerConfig.MessengerAPIToken,
+				event.Ref,
+			)
+		default:
+			_, err = postMessage(ctx, messengerConfig, channel, message)
+		}
+
+		result := escalationResultOK
+		if err != nil {
+			result = escalationResultError
+
+			service.logger.Error(
+				destiny.Describe(
+					"escalation messenger", messenger,
+				).Describe(
+					"escalation channel", channel,
+				).Format(err, "can't escalate problem"),
+			)
+		}
+
+		escalations.WithLabelValues(messenger, result).Inc()
+	}
+
+	post(event.Messenger, event.Channel, escalation.Mention)
+
+	if escalation.Channel != "" {
+		post(event.Messenger, escalation.Channel, "")
+	}
+
+	if escalation.Messenger != "" {
+		post(escalation.Messenger, escalation.MessengerChannel, "")
+	}
+
+	service.logger.Infof(
+		"problem %s in %s %s is escalated",
+		event.EventID,
+		event.Messenger,
+		event.Channel,
+	)
+}
+
+func newEscalationMessage(
+	messenger string,
+	messengerConfig messengerConfig,
+	event *trackedEvent,
+	text string,
+) chat.Message {
+	message := newMessage(messenger)
+	message.SetText(text)
+
+	attachment := message.CreateAttachment(
+		event.Message,
+		messengerConfig.AttachmentsColor,
+	)
+	attachment.SetTitle(escalationTitle)
+	attachment.AddField(false, "Event ID", event.EventID)
+	attachment.AddField(true, "Channel", event.Channel)
+
+	return message
+}
+
+// newMessage - empty message of the chat
+func newMessage(messenger string) chat.Message {
+	switch messenger {
+	case messengerSlack:
+		return chat.NewSlackMessage()
+	case messengerMatrix:
+		return chat.NewMatrixMessage()
+	default:
+		return chat.NewMattermostMessage()
+	}
+}
+
+// postMessage - post message to channel through chat API
+func postMessage(
+	ctx stdcontext.Context,
+	messengerConfig messengerConfig,
+	channel string,
+	message chat.Message,
+) (chat.PostRef, error) {
+	message.SetChannel(channel)
+
+	return message.SendRequest(
+		ctx,
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+	)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/events"
+)
+
+const (
+	defaultEventsCheckInterval = 30 * time.Second
+	defaultEventsTTL           = 72 * time.Hour
+	defaultResolvedColor       = "#2eb886"
+)
+
+// eventsConfig - alerts which are registered by webhook, their
+// events are tracked until they are acknowledged or resolved
+type eventsConfig struct {
+	// Token - bearer token of events route, alerts are not
+	// accepted if it's empty
+	Token string `toml:"token"`
+	// StateFile - where tracked events are kept between restarts,
+	// they are kept only in memory if it's empty
+	StateFile string `toml:"state_file"`
+	// CheckInterval - how often events are checked in Zabbix
+	CheckInterval string `toml:"check_interval"`
+	// TTL - event is forgotten if it's tracked for longer
+	TTL string `toml:"ttl"`
+	// Sync - update posts when problem is acknowledged, resolved
+	// or closed in Zabbix, acknowledged events are tracked until
+	// they are resolved then
+	Sync bool `toml:"sync"`
+	// ResolvedColor - color of posts about resolved problems
+	ResolvedColor string `toml:"resolved_color"`
+}
+
+func (config eventsConfig) getCheckInterval() (time.Duration, error) {
+	return parseServerDuration(
+		"check_interval",
+		config.CheckInterval,
+		defaultEventsCheckInterval,
+	)
+}
+
+func (config eventsConfig) getTTL() (time.Duration, error) {
+	return parseServerDuration(
+		"ttl",
+		config.TTL,
+		defaultEventsTTL,
+	)
+}
+
+// trackedEvent - alert which is posted to chat, its event is
+// checked in Zabbix until it's acknowledged or resolved
+type trackedEvent struct {
+	events.Registration
+	RegisteredAt time.Time `json:"registered_at"`
+	// EscalatedAt - when the event was escalated, zero if it
+	// wasn't escalated yet
+	EscalatedAt time.Time `json:"escalated_at"`
+	// AcknowledgedBy - who has acknowledged the event as it's shown
+	// in the post, empty if it's not acknowledged yet
+	AcknowledgedBy string `json:"acknowledged_by,omitempty"`
+}
+
+// key - the same event can be posted to several channels,
+// every post is tracked separately
+func (event *trackedEvent) key() string {
+	return event.Messenger + "/" + event.Channel + "/" + event.EventID
+}
+
+// eventStore - tracked events, they are saved to the state file
+// after every change if it's configured
+type eventStore struct {
+	mutex  sync.Mutex
+	path   string
+	events map[string]*trackedEvent
+}
+
+func openEventStore(path string) (*eventStore, error) {
+	store := &eventStore{
+		path:   path,
+		events: map[string]*trackedEvent{},
+	}
+
+	if path == "" {
+		return store, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, karma.Describe(
+			"path", path,
+		).Format(err, "can't read events state file")
+	}
+
+	tracked := []*trackedEvent{}
+
+	err = json.Unmarshal(contents, &tracked)
+	if err != nil {
+		return nil, karma.Describe(
+			"path", path,
+		).Format(err, "can't decode events state file")
+	}
+
+	for _, event := range tracked {
+		store.events[event.key()] = event
+	}
+
+	return store, nil
+}
+
+// add - track the event, repeated alert of the same event replaces
+// the post but keeps time of registration, escalation and who has
+// acknowledged it
+func (store *eventStore) add(event *trackedEvent) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if previous, ok := store.events[event.key()]; ok {
+		event.RegisteredAt = previous.RegisteredAt
+		event.EscalatedAt = previous.EscalatedAt
+		event.AcknowledgedBy = previous.AcknowledgedBy
+	}
+
+	store.events[event.key()] = event
+
+	return store.save()
+}
+
+// update - replace tracked event if it's still tracked
+func (store *eventStore) update(event *trackedEvent) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.events[event.key()]; !ok {
+		return nil
+	}
+
+	store.events[event.key()] = event
+
+	return store.save()
+}
+
+func (store *eventStore) remove(removed []*trackedEvent) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, event := range removed {
+		delete(store.events, event.key())
+	}
+
+	return store.save()
+}
+
+// list - copies of tracked events ordered by registration
+func (store *eventStore) list() []*trackedEvent {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tracked := []*trackedEvent{}
+	for _, event := range store.events {
+		event := *event
+		tracked = append(tracked, &event)
+	}
+
+	sort.Slice(tracked, func(i, j int) bool {
+		return tracked[i].RegisteredAt.Before(tracked[j].RegisteredAt)
+	})
+
+	return tracked
+}
+
+func (store *eventStore) len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return len(store.events)
+}
+
+// save - replace state file by the current events, file is
+// written aside and renamed, so it's never left half-written
+func (store *eventStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	destiny := karma.Describe(
+		"path", store.path,
+	)
+
+	tracked := []*trackedEvent{}
+	for _, event := range store.events {
+		tracked = append(tracked, event)
+	}
+
+	contents, err := json.Marshal(tracked)
+	if err != nil {
+		return destiny.Format(err, "can't encode tracked events")
+	}
+
+	file, err := ioutil.TempFile(
+		filepath.Dir(store.path),
+		filepath.Base(store.path)+".tmp",
+	)
+	if err != nil {
+		return destiny.Format(err, "can't write events state file")
+	}
+
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(contents)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+
+	if err != nil {
+		return destiny.Format(err, "can't write events state file")
+	}
+
+	err = os.Rename(file.Name(), store.path)
+	if err != nil {
+		return destiny.Format(err, "can't replace events state file")
+	}
+
+	return nil
+}
+
+// hasBearerToken - whether request is authorized with the token,
+// tokens are compared in constant time, so they can't be guessed
+// by time of answer
+func hasBearerToken(context *gin.Context, token string) bool {
+	return subtle.ConstantTimeCompare(
+		[]byte(context.GetHeader("Authorization")),
+		[]byte("Bearer "+token),
+	) == 1
+}
+
+// handleEvents - register alert which is posted by webhook,
+// its event is tracked until it's acknowledged or resolved
+func (service *actionACKService) handleEvents(context *gin.Context) {
+	destiny := karma.Describe(
+		"method", "handleEvents",
+	)
+
+	config := service.getConfig()
+
+	// route is always served, so token can be set by reload
+	if config.Events.Token == "" {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if !hasBearerToken(context, config.Events.Token) {
+		context.Status(http.StatusUnauthorized)
+		return
+	}
+
+	var registration events.Registration
+
+	err := context.ShouldBindJSON(&registration)
+	if err != nil {
+		context.String(http.StatusBadRequest, "invalid registration: %s", err)
+		return
+	}
+
+	if registration.EventID == "" {
+		context.String(http.StatusBadRequest, "event_id is required")
+		return
+	}
+
+	if _, ok := config.Messenger[registration.Messenger]; !ok {
+		context.String(
+			http.StatusBadRequest,
+			"messenger %s is not configured",
+			registration.Messenger,
+		)
+		return
+	}
+
+	err = service.events.add(&trackedEvent{
+		Registration: registration,
+		RegisteredAt: time.Now(),
+	})
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"eventID", registration.EventID,
+			).Format(err, "can't track event"),
+		)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+
+	// readinessCacheTTL - how long result of dependency checks is
+	// served without new requests to Zabbix and chat
+	readinessCacheTTL = 15 * time.Second
+	readinessTimeout  = 10 * time.Second
+
+	checkStatusOK   = "ok"
+	checkStatusFail = "fail"
+)
+
+// healthCheck - result of one dependency check
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Detail - what is found by the check, e.g. version of Zabbix
+	// or name of the chat bot
+	Detail    string `json:"detail,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// readiness - results of all dependency checks, service is
+// ready if every check is passed
+type readiness struct {
+	Status    string                  `json:"status"`
+	CheckedAt time.Time               `json:"checked_at"`
+	Checks    map[string]*healthCheck `json:"checks"`
+}
+
+// readinessCache - last readiness result, checks are run by one
+// request at a time, others wait for its result
+type readinessCache struct {
+	mutex  sync.Mutex
+	result *readiness
+}
+
+func newReadinessCache() *readinessCache {
+	return &readinessCache{}
+}
+
+func (cache *readinessCache) get(check func() *readiness) *readiness {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.result == nil ||
+		time.Since(cache.result.CheckedAt) > readinessCacheTTL {
+		cache.result = check()
+	}
+
+	return cache.result
+}
+
+// handleHealthz - report that process is alive, nothing
+// is checked
+func (service *actionACKService) handleHealthz(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"status": checkStatusOK})
+}
+
+// handleReadyz - report results of dependency checks as JSON,
+// 503 is returned if any check is failed
+func (service *actionACKService) handleReadyz(context *gin.Context) {
+	result := service.readiness.get(service.checkReadiness)
+
+	status := http.StatusOK
+	if result.Status != checkStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	context.JSON(status, result)
+}
+
+func (service *actionACKService) checkReadiness() *readiness {
+	ctx, cancel := stdcontext.WithTimeout(
+		stdcontext.Background(),
+		readinessTimeout,
+	)
+	defer cancel()
+
+	result := &readiness{
+		Status:    checkStatusOK,
+		CheckedAt: time.Now(),
+		Checks:    map[string]*healthCheck{},
+	}
+
+	checks := map[string]func(stdcontext.Context) (string, error){
+		"config": service.checkConfig,
+		"zabbix": service.checkZabbix,
+	}
+
+	for _, messenger := range service.messengers {
+		messenger := messenger
+
+		checks["messenger."+messenger] = func(
+			ctx stdcontext.Context,
+		) (string, error) {
+			return service.checkMessenger(ctx, messenger)
+		}
+	}
+
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+
+	for name, check := range checks {
+		wait.Add(1)
+
+		go func(name string, check func(stdcontext.Context) (string, error)) {
+			defer wait.Done()
+
+			start := time.Now()
+
+			detail, err := check(ctx)
+
+			healthCheck := &healthCheck{
+				Status:    checkStatusOK,
+				Detail:    detail,
+				LatencyMS: time.Since(start).Milliseconds(),
+			}
+
+			if err != nil {
+				healthCheck.Status = checkStatusFail
+				healthCheck.Error = secrets.Redact(err.Error())
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			result.Checks[name] = healthCheck
+			if err != nil {
+				result.Status = checkStatusFail
+			}
+		}(name, check)
+	}
+
+	wait.Wait()
+
+	return result
+}
+
+// checkConfig - make sure that everything which is required
+// to do actions is set
+func (service *actionACKService) checkConfig(
+	ctx stdcontext.Context,
+) (string, error) {
+	if service.getConfig().Zabbix.URL == "" {
+		return "", fmt.Errorf("zabbix_api_url is not set")
+	}
+
+	for _, messenger := range service.messengers {
+		messengerConfig, ok := service.getConfig().Messenger[messenger]
+		if !ok {
+			return "", fmt.Errorf(
+				"messenger.%s section is not found",
+				messenger,
+			)
+		}
+
+		if messengerConfig.MessengerAPIURL == "" ||
+			messengerConfig.MessengerAPIToken == "" {
+			return "", fmt.Errorf(
+				"messenger_api_url and messenger_api_token of %s are required",
+				messenger,
+			)
+		}
+	}
+
+	return strings.Join(service.messengers, ","), nil
+}
+
+// checkZabbix - make sure that Zabbix API is reachable
+// and accepts credentials of service account
+func (service *actionACKService) checkZabbix(
+	ctx stdcontext.Context,
+) (string, error) {
+	version, err := service.getZabbix().Check(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return version.String(), nil
+}
+
+// checkMessenger - make sure that token of chat API is valid,
+// name of the bot user is returned
+func (service *actionACKService) checkMessenger(
+	ctx stdcontext.Context,
+	messenger string,
+) (string, error) {
+	messengerConfig := service.getConfig().Messenger[messenger]
+
+	switch messenger {
+	case messengerSlack:
+		return checkSlackAuth(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+		)
+	case messengerMattermost:
+		return checkMattermostAuth(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+		)
+	case messengerMatrix:
+		return fetchMatrixBotUser(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+		)
+	}
+
+	return "", fmt.Errorf("unknown messenger %s", messenger)
+}
+
+func checkSlackAuth(
+	ctx stdcontext.Context,
+	chatAPIURL string,
+	chatAPIToken string,
+) (string, error) {
+	destiny := karma.Describe(
+		"method", "checkSlackAuth",
+	).Describe(
+		"url", secrets.Redact(chatAPIURL),
+	)
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s/auth.test", chatAPIURL),
+		nil,
+	)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf("Bearer %s", chatAPIToken),
+	)
+
+	response, err := doChatRequest(transport.Client(), request, messengerSlack)
+	if err != nil {
+		return "", destiny.Describe(
+			"error", err,
+		).Reason("can't execute HTTP request")
+	}
+
+	defer response.Body.Close()
+
+	var answer struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		User  string `json:"user"`
+		Team  string `json:"team"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&answer)
+	if err != nil {
+		return "", destiny.Describe(
+			"status code", response.StatusCode,
+		).Describe(
+			"error", err,
+		).Reason("can't decode JSON response from Slack")
+	}
+
+	if !answer.OK {
+		return "", destiny.Describe(
+			"error", answer.Error,
+		).Reason("Slack rejected the token")
+	}
+
+	return answer.User + "@" + answer.Team, nil
+}
+
+func checkMattermostAuth(
+	ctx stdcontext.Context,
+	chatURL string,
+	authToken string,
+) (string, error) {
+	destiny := karma.Describe(
+		"method", "checkMattermostAuth",
+	).Describe(
+		"url", secrets.Redact(chatURL),
+	)
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		fmt.Sprintf("%s/users/me", chatURL),
+		nil,
+	)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf("Bearer %s", authToken),
+	)
+
+	response, err := doChatRequest(
+		transport.Client(),
+		request,
+		messengerMattermost,
+	)
+	if err != nil {
+		return "", destiny.Describe(
+			"error", err,
+		).Reason("can't execute HTTP request")
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", destiny.Describe(
+			"status code", response.StatusCode,
+		).Reason("Mattermost rejected the token")
+	}
+
+	var answer mattermostUser
+
+	err = json.NewDecoder(response.Body).Decode(&answer)
+	if err != nil {
+		return "", destiny.Describe(
+			"error", err,
+		).Reason("can't decode JSON response from Mattermost")
+	}
+
+	return answer.Username, nil
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/transport"
+)
+
+const (
+	matrixSyncTimeout = 30 * time.Second
+	matrixRetryDelay  = 5 * time.Second
+
+	// only reactions are interesting for chattixd, so everything
+	// else is filtered out on homeserver side
+	matrixSyncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},` +
+		`"room":{"timeline":{"types":["m.reaction"]},"state":{"types":[]},` +
+		`"ephemeral":{"types":[]},"account_data":{"types":[]}}}`
+)
+
+type matrixEvent struct {
+	Type    string          `json:"type"`
+	EventID string          `json:"event_id"`
+	Sender  string          `json:"sender"`
+	RoomID  string          `json:"room_id"`
+	Content json.RawMessage `json:"content"`
+}
+
+type matrixReactionContent struct {
+	RelatesTo chat.MatrixRelation `json:"m.relates_to"`
+}
+
+// matrixChattixContent - action contexts of alert keyed by
+// reaction which performs an action
+type matrixChattixContent struct {
+	Contexts map[string]*context.ContextActionACK `json:"io.github.zarplata.chattix"`
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []*matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+type matrixWhoAmIResponse struct {
+	UserID string `json:"user_id"`
+}
+
+type matrixProfileResponse struct {
+	DisplayName string `json:"displayname"`
+}
+
+// watchMatrix - follows /sync of the bot user and acknowledges
+// Zabbix events when ack reaction is put on the alert message
+func (service *actionACKService) watchMatrix(ctx stdcontext.Context) {
+	destiny := karma.Describe(
+		"method", "watchMatrix",
+	)
+
+	var (
+		botUserID string
+		since     string
+		err       error
+	)
+
+	for ctx.Err() == nil {
+		// config is read on every sync, so reloaded token
+		// is used by the next request
+		messengerConfig := service.getConfig().Messenger[messengerMatrix]
+
+		if botUserID == "" {
+			botUserID, err = fetchMatrixBotUser(
+				ctx,
+				messengerConfig.MessengerAPIURL,
+				messengerConfig.MessengerAPIToken,
+			)
+			if err != nil {
+				service.logger.Error(
+					destiny.Describe(
+						"error", err,
+					).Reason(
+						"can't fetch Matrix bot user",
+					),
+				)
+
+				sleepContext(ctx, matrixRetryDelay)
+				continue
+			}
+		}
+
+		// the first sync is done without timeout only to get
+		// a position in the timeline, old reactions are skipped
+		timeout := matrixSyncTimeout
+		if since == "" {
+			timeout = 0
+		}
+
+		sync, err := syncMatrix(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			since,
+			timeout,
+		)
+		if err != nil {
+			service.logger.Error(
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't sync with Matrix homeserver",
+				),
+			)
+
+			sleepContext(ctx, matrixRetryDelay)
+			continue
+		}
+
+		if since != "" {
+			for roomID, room := range sync.Rooms.Join {
+				for _, event := range room.Timeline.Events {
+					if event.Sender == botUserID {
+						continue
+					}
+
+					event.RoomID = roomID
+
+					// action isn't interrupted by shutdown,
+					// it's waited for instead
+					service.handleMatrixReaction(
+						stdcontext.Background(),
+						event,
+						botUserID,
+					)
+				}
+			}
+		}
+
+		since = sync.NextBatch
+	}
+}
+
+// sleepContext - sleep for duration or until context is done
+func sleepContext(ctx stdcontext.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// handleMatrixReaction - perform action of reaction which is put
+// on alert posted by the bot user, contexts of messages posted by
+// anybody else are not trusted
+func (service *actionACKService) handleMatrixReaction(
+	ctx stdcontext.Context,
+	event *matrixEvent,
+	botUserID string,
+) {
+	destiny := karma.Describe(
+		"method", "handleMatrixReaction",
+	).Describe(
+		"room", event.RoomID,
+	).Describe(
+		"reaction", event.EventID,
+	)
+
+	messengerConfig := service.getConfig().Messenger[messengerMatrix]
+
+	if event.Type != "m.reaction" {
+		return
+	}
+
+	var reaction matrixReactionContent
+
+	err := json.Unmarshal(event.Content, &reaction)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't unmarshal reaction from Matrix",
+			),
+		)
+		return
+	}
+
+	if reaction.RelatesTo.RelationType != "m.annotation" {
+		return
+	}
+
+	alertEventID := reaction.RelatesTo.EventID
+
+	destiny = destiny.Describe(
+		"alert", alertEventID,
+	)
+
+	actionContext, err := fetchMatrixActionContext(
+		ctx,
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		event.RoomID,
+		alertEventID,
+		botUserID,
+		reaction.RelatesTo.Key,
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't fetch alert event from Matrix",
+			),
+		)
+		return
+	}
+
+	// reaction on the message which was not posted by chattix
+	// or reaction which performs no action
+	if actionContext == nil {
+		return
+	}
+
+	err = service.verifyActionContext(messengerMatrix, actionContext)
+	if err != nil {
+		service.logger.Warning(
+			destiny.Describe(
+				"user", event.Sender,
+			).Describe(
+				"error", err,
+			).Reason(
+				"action context failed verification",
+			),
+		)
+		return
+	}
+
+	user, err := fetchUserFromMatrix(
+		ctx,
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		event.Sender,
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't fetch user from Matrix",
+			),
+		)
+		return
+	}
+
+	err = service.authorize(
+		ctx,
+		user,
+		actionContext.Channel,
+		actionContext.Action,
+		actionContext.EventID,
+	)
+	if err != nil {
+		if !karma.Contains(err, errActionNotAllowed) {
+			service.logger.Error(
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't authorize action",
+				),
+			)
+			return
+		}
+
+		service.logger.Warning(err)
+
+		// Matrix has no ephemeral messages, so user
+		// is answered in reply to the alert
+		notice := &chat.MatrixMessage{}
+		notice.CreateAttachment(
+			fmt.Sprintf("%s: %s", user.Name, notAllowedText),
+			messengerConfig.AttachmentsColor,
+		)
+
+		_, err = notice.Reply(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			chat.PostRef{
+				Channel: event.RoomID,
+				ID:      alertEventID,
+			},
+		)
+		if err != nil {
+			service.logger.Error(
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't reply to alert message in Matrix",
+				),
+			)
+		}
+
+		return
+	}
+
+	authorMessage := strings.Replace(
+		messengerConfig.AuthorMessage,
+		usernamePlaceholder,
+		user.Name,
+		-1,
+	)
+
+	acknowledgedBy, err := service.acknowledge(
+		ctx,
+		user,
+		actionContext.Channel,
+		actionContext.Action,
+		actionAcknowledgement(actionContext, authorMessage, user.Name),
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't acknowledge Zabbix event",
+			),
+		)
+		return
+	}
+
+	submission := actionSubmission(actionContext)
+	status := getActionStatus(actionContext.ZabbixAction)
+
+	matrixMessage := &chat.MatrixMessage{}
+
+	attachmentZabbix := &chat.MatrixAttachment{
+		Color:      messengerConfig.AttachmentsColor,
+		AuthorName: formatAuthorMessage(messengerConfig, acknowledgedBy),
+		AuthorIcon: messengerConfig.AuthorImageURL,
+	}
+
+	if submission.Close {
+		attachmentZabbix.AddField(true, "Problem", "Closed")
+	}
+
+	if submission.Severity != "" {
+		attachmentZabbix.AddField(
+			true,
+			"Severity",
+			getZabbixSeverityName(submission.Severity),
+		)
+	}
+
+	alertRef := chat.PostRef{
+		Channel: event.RoomID,
+		ID:      alertEventID,
+	}
+
+	// edit drops actions of the alert, so action which doesn't
+	// resolve it is posted as reply
+	if !isResolvingAction(actionContext.ZabbixAction) {
+		attachmentZabbix.SetTitle(status)
+		matrixMessage.Attachments = append(
+			matrixMessage.Attachments,
+			attachmentZabbix,
+		)
+
+		_, err = matrixMessage.Reply(
+			ctx,
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			alertRef,
+		)
+		if err != nil {
+			service.logger.Error(
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't reply to alert message in Matrix",
+				),
+			)
+		}
+
+		return
+	}
+
+	attachment := matrixMessage.CreateAttachment(
+		actionContext.Message,
+		messengerConfig.AttachmentsColor,
+	)
+	attachment.SetTitle(status)
+	attachment.AddField(false, "Event ID", actionContext.EventID)
+
+	matrixMessage.Attachments = append(
+		matrixMessage.Attachments,
+		attachmentZabbix,
+	)
+
+	err = matrixMessage.Update(
+		ctx,
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		alertRef,
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't update alert message in Matrix",
+			),
+		)
+	}
+}
+
+func fetchMatrixBotUser(
+	ctx stdcontext.Context,
+	homeserverURL string,
+	authToken string,
+) (string, error) {
+	var answer matrixWhoAmIResponse
+
+	err := chat.CallMatrix(
+		ctx,
+		transport.Client(),
+		"GET",
+		chat.MatrixAPIURL(homeserverURL, "account", "whoami"),
+		authToken,
+		nil,
+		&answer,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return answer.UserID, nil
+}
+
+func syncMatrix(
+	ctx stdcontext.Context,
+	homeserverURL string,
+	authToken string,
+	since string,
+	timeout time.Duration,
+) (*matrixSyncResponse, error) {
+	query := url.Values{}
+	query.Set("filter", matrixSyncFilter)
+	query.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var answer matrixSyncResponse
+
+	// long polling request should not be interrupted by
+	// the timeout of HTTP client
+	client := transport.ClientWithTimeout(
+		transport.Client().Timeout + timeout,
+	)
+
+	err := chat.CallMatrix(
+		ctx,
+		client,
+		"GET",
+		chat.MatrixAPIURL(homeserverURL, "sync")+"?"+query.Encode(),
+		authToken,
+		nil,
+		&answer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &answer, nil
+}
+
+func fetchMatrixActionContext(
+	ctx stdcontext.Context,
+	homeserverURL string,
+	authToken string,
+	roomID string,
+	eventID string,
+	botUserID string,
+	reactionKey string,
+) (*context.ContextActionACK, error) {
+	var event matrixEvent
+
+	err := chat.CallMatrix(
+		ctx,
+		transport.Client(),
+		"GET",
+		chat.MatrixAPIURL(homeserverURL, "rooms", roomID, "event", eventID),
+		authToken,
+		nil,
+		&event,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// any member of the room can post message with action contexts,
+	// only alerts which are posted by the bot are trusted
+	if event.Type != "m.room.message" ||
+		event.Sender != botUserID ||
+		len(event.Content) == 0 {
+		return nil, nil
+	}
+
+	var content matrixChattixContent
+
+	err = json.Unmarshal(event.Content, &content)
+	if err != nil {
+		return nil, err
+	}
+
+	actionContext := content.Contexts[reactionKey]
+	if actionContext == nil || actionContext.EventID == "" {
+		return nil, nil
+	}
+
+	return actionContext, nil
+}
+
+func fetchUserFromMatrix(
+	ctx stdcontext.Context,
+	homeserverURL string,
+	authToken string,
+	userID string,
+) (*chatUser, error) {
+	var answer matrixProfileResponse
+
+	err := chat.CallMatrix(
+		ctx,
+		transport.Client(),
+		"GET",
+		chat.MatrixAPIURL(homeserverURL, "profile", userID, "displayname"),
+		authToken,
+		nil,
+		&answer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matrix doesn't share email of user, so localpart
+	// of user ID is used as login
+	user := &chatUser{
+		Messenger: messengerMatrix,
+		ID:        userID,
+		Name:      answer.DisplayName,
+		Login:     strings.SplitN(strings.TrimPrefix(userID, "@"), ":", 2)[0],
+	}
+
+	if user.Name == "" {
+		user.Name = userID
+	}
+
+	return user, nil
+}
@@ -2,31 +2,343 @@ package main
 
 import (
 	"bytes"
+	stdcontext "context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
 	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
 )
 
 type actionRequest struct {
-	UserID  string                   `json:"user_id"`
-	Context context.ContextActionACK `json:"context"`
+	UserID    string                   `json:"user_id"`
+	ChannelID string                   `json:"channel_id"`
+	PostID    string                   `json:"post_id"`
+	TriggerID string                   `json:"trigger_id"`
+	Context   context.ContextActionACK `json:"context"`
+}
+
+// mattermostDialogState - state which is passed through interactive
+// dialog to know which post should be changed on submission and who
+// has opened the dialog. State is signed as a whole, otherwise valid
+// context could be submitted with post of somebody else or by
+// another user.
+type mattermostDialogState struct {
+	PostID    string                   `json:"post_id"`
+	UserID    string                   `json:"user_id"`
+	Context   context.ContextActionACK `json:"context"`
+	Expires   int64                    `json:"expires"`
+	Signature string                   `json:"signature"`
+}
+
+func (state *mattermostDialogState) sign(key []byte, expires time.Time) {
+	state.Expires = expires.Unix()
+	state.Signature = state.signature(key)
+}
+
+func (state *mattermostDialogState) verify(key []byte, now time.Time) error {
+	if !hmac.Equal([]byte(state.signature(key)), []byte(state.Signature)) {
+		return context.ErrInvalidSignature
+	}
+
+	if now.Unix() > state.Expires {
+		return context.ErrExpired
+	}
+
+	return nil
+}
+
+func (state *mattermostDialogState) signature(key []byte) string {
+	// context is encoded with its own signature, so
+	// every field of it is signed too
+	encodedContext, _ := json.Marshal(state.Context)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(
+		[]string{
+			state.PostID,
+			state.UserID,
+			string(encodedContext),
+			strconv.FormatInt(state.Expires, 10),
+		},
+		"\x00",
+	)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newMattermostDialogState - signed state of dialog which is opened
+// for the post by the user, dialog can be submitted within dialogTTL
+func (service *actionACKService) newMattermostDialogState(
+	postID string,
+	userID string,
+	actionContext context.ContextActionACK,
+) mattermostDialogState {
+	state := mattermostDialogState{
+		PostID:  postID,
+		UserID:  userID,
+		Context: actionContext,
+	}
+
+	state.sign(service.dialogStateKey(), time.Now().Add(dialogTTL))
+
+	return state
+}
+
+// dialogStateKey - key of dialog state signature, action secret is
+// used if it's shared with webhook, so any instance of chattixd
+// accepts the state, otherwise the key of the process is used
+func (service *actionACKService) dialogStateKey() []byte {
+	secret := service.getConfig().Messenger[messengerMattermost].ActionSecret
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	return service.stateKey
+}
+
+type mattermostDialogRequest struct {
+	Type       string                 `json:"type"`
+	CallbackID string                 `json:"callback_id"`
+	State      string                 `json:"state"`
+	UserID     string                 `json:"user_id"`
+	ChannelID  string                 `json:"channel_id"`
+	Cancelled  bool                   `json:"cancelled"`
+	Submission map[string]interface{} `json:"submission"`
+}
+
+type mattermostDialogElement struct {
+	DisplayName string                   `json:"display_name"`
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type"`
+	Optional    bool                     `json:"optional"`
+	Placeholder string                   `json:"placeholder,omitempty"`
+	HelpText    string                   `json:"help_text,omitempty"`
+	Options     []mattermostDialogOption `json:"options,omitempty"`
+}
+
+type mattermostDialogOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// submission - parse values filled by user in dialog
+func (request *mattermostDialogRequest) submission() dialogSubmission {
+	submission := dialogSubmission{}
+
+	if comment, ok := request.Submission[dialogCommentField].(string); ok {
+		submission.Comment = strings.TrimSpace(comment)
+	}
+
+	if closeProblem, ok := request.Submission[dialogCloseField].(bool); ok {
+		submission.Close = closeProblem
+	}
+
+	if severity, ok := request.Submission[dialogSeverityField].(string); ok {
+		submission.Severity = severity
+	}
+
+	return submission
+}
+
+func openMattermostDialog(
+	ctx stdcontext.Context,
+	chatURL string,
+	authToken string,
+	triggerID string,
+	dialogURL string,
+	state mattermostDialogState,
+) error {
+	destiny := karma.Describe(
+		"method", "openMattermostDialog",
+	).Describe(
+		"url", secrets.Redact(chatURL),
+	)
+
+	encodedState, err := json.Marshal(state)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't marshal dialog state")
+	}
+
+	severityOptions := []mattermostDialogOption{}
+	for _, severity := range zabbixSeverities {
+		severityOptions = append(
+			severityOptions,
+			mattermostDialogOption{
+				Text:  severity.Name,
+				Value: severity.Value,
+			},
+		)
+	}
+
+	payload := map[string]interface{}{
+		"trigger_id": triggerID,
+		"url":        dialogURL,
+		"dialog": map[string]interface{}{
+			"callback_id":  dialogCallbackID,
+			"title":        dialogTitle,
+			"submit_label": dialogSubmitLabel,
+			"state":        string(encodedState),
+			"elements": []mattermostDialogElement{
+				{
+					DisplayName: "Comment",
+					Name:        dialogCommentField,
+					Type:        "textarea",
+					Optional:    true,
+					Placeholder: "Reason, ETA",
+				},
+				{
+					DisplayName: "Close problem",
+					Name:        dialogCloseField,
+					Type:        "bool",
+					Optional:    true,
+				},
+				{
+					DisplayName: "Severity",
+					Name:        dialogSeverityField,
+					Type:        "select",
+					Optional:    true,
+					HelpText:    "Leave empty to keep current severity",
+					Options:     severityOptions,
+				},
+			},
+		},
+	}
+
+	body := new(bytes.Buffer)
+
+	err = json.NewEncoder(body).Encode(payload)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't marshal request payload")
+	}
+
+	requestURL := fmt.Sprintf(
+		"%s/actions/dialogs/open",
+		chatURL,
+	)
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		requestURL,
+		body,
+	)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't create HTTP request")
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf("Bearer %s", authToken),
+	)
+
+	response, err := doChatRequest(transport.Client(), request, messengerMattermost)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't execute HTTP request")
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return destiny.Describe(
+			"status code", response.StatusCode,
+		).Reason(
+			"unexpected status code from Mattermost API",
+		)
+	}
+
+	return nil
+}
+
+type mattermostUser struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// newMattermostAcknowledgedMessage - build message which replaces
+// alert after acknowledgement or other resolving action
+func newMattermostAcknowledgedMessage(
+	actionContext context.ContextActionACK,
+	messengerConfig messengerConfig,
+	authorMessage string,
+	submission dialogSubmission,
+) *chat.MattermostMessage {
+	mattermostMessage := &chat.MattermostMessage{
+		ChannelName: actionContext.Channel,
+		Username:    actionContext.Username,
+		IconURL:     actionContext.IconURL,
+	}
+
+	attachment := &chat.MattermostAttachment{
+		Color: messengerConfig.AttachmentsColor,
+		Text:  actionContext.Message,
+		Title: getActionStatus(actionContext.ZabbixAction),
+	}
+
+	attachment.AddField(
+		false,
+		"Event ID",
+		actionContext.EventID,
+	)
+
+	attachmentZabbix := &chat.MattermostAttachment{
+		Color:      messengerConfig.AttachmentsColor,
+		AuthorName: authorMessage,
+		AuthorIcon: messengerConfig.AuthorImageURL,
+		Text:       submission.Comment,
+	}
+
+	if submission.Close {
+		attachmentZabbix.AddField(true, "Problem", "Closed")
+	}
+
+	if submission.Severity != "" {
+		attachmentZabbix.AddField(
+			true,
+			"Severity",
+			getZabbixSeverityName(submission.Severity),
+		)
+	}
+
+	mattermostMessage.Attachments = append(
+		mattermostMessage.Attachments,
+		attachment,
+		attachmentZabbix,
+	)
+
+	return mattermostMessage
 }
 
 func fetchUserFromMattermost(
+	ctx stdcontext.Context,
 	chatURL string,
 	authToken string,
 	userID string,
-) (string, error) {
+) (*chatUser, error) {
 	destiny := karma.Describe(
 		"method", "fetchUserFromMattermost",
 	).Describe(
-		"url", chatURL,
-	).Describe(
-		"authToken", authToken,
+		"url", secrets.Redact(chatURL),
 	).Describe(
 		"userID", userID,
 	)
@@ -39,7 +351,7 @@ func fetchUserFromMattermost(
 
 	err := json.NewEncoder(body).Encode(payload)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason("can't marshal request payload")
 	}
@@ -50,17 +362,18 @@ func fetchUserFromMattermost(
 	)
 
 	destiny.Describe(
-		"request URL", requestURL,
+		"request URL", secrets.Redact(requestURL),
 	)
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		requestURL,
 		body,
 	)
 
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason("can't create HTTP request")
 	}
@@ -70,10 +383,9 @@ func fetchUserFromMattermost(
 		fmt.Sprintf("Bearer %s", authToken),
 	)
 
-	client := http.Client{}
-	response, err := client.Do(request)
+	response, err := doChatRequest(transport.Client(), request, messengerMattermost)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason("can't execute HTTP request")
 	}
@@ -96,24 +408,24 @@ func fetchUserFromMattermost(
 
 		err = json.NewDecoder(response.Body).Decode(&answer)
 		if err != nil {
-			return "", destiny.Describe(
+			return nil, destiny.Describe(
 				"error", err,
 			).Reason(
 				"can't decode JSON response from Mattermost",
 			)
 		}
 
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"status code", answer["status_code"].(int),
 		).Describe(
 			"request id", answer["request_id"].(string),
 		).Reason(answer["message"].(string))
 	}
 
-	answer := []interface{}{}
+	answer := []*mattermostUser{}
 	err = json.NewDecoder(response.Body).Decode(&answer)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason(
 			"can't decode JSON response from Mattermost",
@@ -121,7 +433,7 @@ func fetchUserFromMattermost(
 	}
 
 	if len(answer) != 1 {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"user count", len(answer),
 		).Reason(
 			"unexpected count of user requrned, expected 1",
@@ -131,10 +443,16 @@ func fetchUserFromMattermost(
 	fullName := strings.TrimSpace(
 		fmt.Sprintf(
 			"%s %s",
-			answer[0].(map[string]interface{})["first_name"].(string),
-			answer[0].(map[string]interface{})["last_name"].(string),
+			answer[0].FirstName,
+			answer[0].LastName,
 		),
 	)
 
-	return fullName, nil
+	return &chatUser{
+		Messenger: messengerMattermost,
+		ID:        userID,
+		Name:      fullName,
+		Login:     answer[0].Username,
+		Email:     answer[0].Email,
+	}, nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zarplata/chattix/context"
+)
+
+func TestMattermostDialogState_Verify(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(dialogTTL)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		tamper func(state *mattermostDialogState)
+		err    error
+	}{
+		{
+			name: "valid",
+			now:  now,
+		},
+		{
+			name: "another user",
+			now:  now,
+			tamper: func(state *mattermostDialogState) {
+				state.UserID = "intruder"
+			},
+			err: context.ErrInvalidSignature,
+		},
+		{
+			name: "another post",
+			now:  now,
+			tamper: func(state *mattermostDialogState) {
+				state.PostID = "another-post"
+			},
+			err: context.ErrInvalidSignature,
+		},
+		{
+			name: "another event",
+			now:  now,
+			tamper: func(state *mattermostDialogState) {
+				state.Context.EventID = "43"
+			},
+			err: context.ErrInvalidSignature,
+		},
+		{
+			name: "expired",
+			now:  expires.Add(time.Second),
+			err:  context.ErrExpired,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := mattermostDialogState{
+				PostID:  "post",
+				UserID:  "opener",
+				Context: context.ContextActionACK{EventID: "42"},
+			}
+
+			state.sign(key, expires)
+
+			if test.tamper != nil {
+				test.tamper(&state)
+			}
+
+			err := state.verify(key, test.now)
+			if err != test.err {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/metrics"
+)
+
+const (
+	metricsPath = "/metrics"
+
+	userLookupNotMapped = "not_mapped"
+	userLookupNotFound  = "not_found"
+	userLookupError     = "error"
+
+	escalationResultOK    = "ok"
+	escalationResultError = "error"
+
+	syncResultOK    = "ok"
+	syncResultError = "error"
+)
+
+var (
+	ackRequests = metrics.NewCounter(
+		"chattix_ack_requests_total",
+		"Actions of chat users with alerts and silences of hosts by messenger and outcome.",
+		"messenger", "outcome",
+	)
+
+	userLookupFailures = metrics.NewCounter(
+		"chattix_user_lookup_failures_total",
+		"Chat users who can't be mapped to Zabbix users by reason.",
+		"reason",
+	)
+
+	escalations = metrics.NewCounter(
+		"chattix_escalations_total",
+		"Posts about problems which are not acknowledged in time by messenger and result.",
+		"messenger", "result",
+	)
+
+	syncedPosts = metrics.NewCounter(
+		"chattix_synced_posts_total",
+		"Posts updated after problems were changed in Zabbix by messenger and result.",
+		"messenger", "result",
+	)
+)
+
+// setMetrics - serve metrics for Prometheus and register
+// gauges which are computed from the state of service. There is
+// no spool of undelivered messages, failed alerts are retried by
+// Zabbix, so pending dialogs and tracked events are the only queues.
+func (service *actionACKService) setMetrics() {
+	metrics.NewGaugeFunc(
+		"chattix_slack_pending_dialogs",
+		"Slack modals which are opened and not submitted yet.",
+		func() float64 {
+			return float64(service.slackPendingAcks.len())
+		},
+	)
+
+	metrics.NewGaugeFunc(
+		"chattix_tracked_events",
+		"Posted problems which are tracked until acknowledgement or resolution.",
+		func() float64 {
+			return float64(service.events.len())
+		},
+	)
+
+	service.gin.GET(metricsPath, gin.WrapH(metrics.Handler()))
+}
+
+// doChatRequest - execute request to chat API and
+// observe its latency
+func doChatRequest(
+	client *http.Client,
+	request *http.Request,
+	messenger string,
+) (*http.Response, error) {
+	start := time.Now()
+
+	response, err := client.Do(request)
+
+	statusCode := 0
+	if response != nil {
+		statusCode = response.StatusCode
+	}
+
+	chat.RequestDuration.WithLabelValues(
+		messenger,
+		chat.RequestResult(err, statusCode),
+	).Observe(time.Since(start).Seconds())
+
+	return response, err
+}
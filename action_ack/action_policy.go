@@ -0,0 +1,283 @@
+package main
+
+import (
+	stdcontext "context"
+	"errors"
+	"strings"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+
+	notAllowedText = "You are not allowed to do this action"
+)
+
+// errActionNotAllowed - chat user is not allowed to do action
+// with the alert by authorization policy
+var errActionNotAllowed = errors.New("action is not allowed by policy")
+
+// policyConfig - who may do actions with alerts, everyone may do
+// everything if there are no rules
+type policyConfig struct {
+	// Default - "allow" or "deny" action if no rule matches the alert
+	Default string `toml:"default"`
+	// CheckZabbixPermissions - require read-write permission of mapped
+	// Zabbix user on hosts of the alert
+	CheckZabbixPermissions bool `toml:"check_zabbix_permissions"`
+	// Groups - named lists of chat users (login or email)
+	Groups map[string][]string `toml:"groups"`
+	Rules  []policyRule        `toml:"rules"`
+}
+
+// policyRule - users and groups who may do actions with alerts in
+// passed channels, host groups and severities. Empty list matches
+// everything.
+type policyRule struct {
+	Channels   []string `toml:"channels"`
+	HostGroups []string `toml:"host_groups"`
+	Severities []string `toml:"severities"`
+	Users      []string `toml:"users"`
+	Groups     []string `toml:"groups"`
+}
+
+// needsEvent - whether rule can't be matched without Zabbix event
+func (rule policyRule) needsEvent() bool {
+	return len(rule.HostGroups) > 0 || len(rule.Severities) > 0
+}
+
+func (rule policyRule) matchesAlert(channel string, event *zabbix.Event) bool {
+	if len(rule.Channels) > 0 &&
+		!containsFold(rule.Channels, strings.TrimPrefix(channel, "#")) {
+		return false
+	}
+
+	if len(rule.Severities) > 0 &&
+		!containsFold(rule.Severities, event.Severity) &&
+		!containsFold(rule.Severities, getZabbixSeverityName(event.Severity)) {
+		return false
+	}
+
+	if len(rule.HostGroups) > 0 {
+		for _, host := range event.Hosts {
+			for _, group := range host.Groups {
+				if containsFold(rule.HostGroups, group.Name) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (rule policyRule) allowsUser(
+	user *chatUser,
+	groups map[string][]string,
+) bool {
+	if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+
+	members := append([]string{}, rule.Users...)
+	for _, group := range rule.Groups {
+		members = append(members, groups[group]...)
+	}
+
+	return (user.Login != "" && containsFold(members, user.Login)) ||
+		(user.Email != "" && containsFold(members, user.Email))
+}
+
+// authorize - check that chat user may do action with alert of
+// passed Zabbix event which is posted in passed channel, denied
+// actions are recorded in audit log
+func (service *actionACKService) authorize(
+	ctx stdcontext.Context,
+	user *chatUser,
+	channel string,
+	action string,
+	eventID string,
+) error {
+	return service.authorizeAlert(
+		ctx,
+		user,
+		channel,
+		action,
+		&auditRecord{EventID: eventID},
+		func() (*zabbix.Event, error) {
+			return service.getZabbix().GetEvent(ctx, eventID)
+		},
+	)
+}
+
+// authorizeHost - check that chat user may do action with the host,
+// e.g. silence it, the host is matched as alert without severity,
+// so rules with severities don't match it
+func (service *actionACKService) authorizeHost(
+	ctx stdcontext.Context,
+	user *chatUser,
+	channel string,
+	action string,
+	host *zabbix.Host,
+) error {
+	return service.authorizeAlert(
+		ctx,
+		user,
+		channel,
+		action,
+		&auditRecord{Host: host.Host},
+		func() (*zabbix.Event, error) {
+			return &zabbix.Event{Hosts: []*zabbix.Host{host}}, nil
+		},
+	)
+}
+
+// authorizeAlert - check policy for alert which is got by getEvent
+// only if rules need it, denied action is recorded in audit log
+// together with subject of the record
+func (service *actionACKService) authorizeAlert(
+	ctx stdcontext.Context,
+	user *chatUser,
+	channel string,
+	action string,
+	subject *auditRecord,
+	getEvent func() (*zabbix.Event, error),
+) error {
+	policy := service.getConfig().Policy
+
+	if len(policy.Rules) == 0 && !policy.CheckZabbixPermissions {
+		return nil
+	}
+
+	err := service.checkPolicy(
+		ctx,
+		user,
+		channel,
+		subject.EventID,
+		getEvent,
+	)
+	if karma.Contains(err, errActionNotAllowed) {
+		service.audit(&auditRecord{
+			Time:      time.Now(),
+			Messenger: user.Messenger,
+			UserID:    user.ID,
+			UserName:  user.Name,
+			UserLogin: user.Login,
+			EventID:   subject.EventID,
+			Host:      subject.Host,
+			Action:    action,
+			Channel:   channel,
+			Result:    auditResultDenied,
+			Error:     secrets.Redact(err.Error()),
+		})
+	}
+
+	return err
+}
+
+// checkPolicy - match alert with rules of policy and check
+// permissions of Zabbix user if it's required
+func (service *actionACKService) checkPolicy(
+	ctx stdcontext.Context,
+	user *chatUser,
+	channel string,
+	eventID string,
+	getEvent func() (*zabbix.Event, error),
+) error {
+	policy := service.getConfig().Policy
+
+	destiny := karma.Describe(
+		"method", "authorize",
+	).Describe(
+		"login", user.Login,
+	).Describe(
+		"channel", channel,
+	).Describe(
+		"eventID", eventID,
+	)
+
+	// rules without hosts and severities are matched without
+	// request to Zabbix
+	event := &zabbix.Event{EventID: eventID}
+
+	needsEvent := policy.CheckZabbixPermissions
+	for _, rule := range policy.Rules {
+		needsEvent = needsEvent || rule.needsEvent()
+	}
+
+	if needsEvent {
+		var err error
+
+		event, err = getEvent()
+		if err != nil {
+			return destiny.Format(err, "can't get Zabbix event")
+		}
+	}
+
+	allowed := policy.Default != policyDeny
+	for _, rule := range policy.Rules {
+		if !rule.matchesAlert(channel, event) {
+			continue
+		}
+
+		allowed = rule.allowsUser(user, policy.Groups)
+		if allowed {
+			break
+		}
+	}
+
+	if !allowed {
+		return destiny.Reason(errActionNotAllowed)
+	}
+
+	if !policy.CheckZabbixPermissions {
+		return nil
+	}
+
+	client, err := service.getUsers().userClient(ctx, user)
+	if err != nil {
+		if karma.Contains(err, errUserNotMapped) ||
+			karma.Contains(err, zabbix.ErrUserNotFound) {
+			return destiny.Describe(
+				"error", err,
+			).Reason(errActionNotAllowed)
+		}
+
+		return destiny.Reason(err)
+	}
+
+	hostIDs := []string{}
+	for _, host := range event.Hosts {
+		hostIDs = append(hostIDs, host.HostID)
+	}
+
+	editable, err := client.CanEditHosts(ctx, hostIDs)
+	if err != nil {
+		return destiny.Format(err, "can't check permissions of Zabbix user")
+	}
+
+	if !editable {
+		return destiny.Describe(
+			"hosts", hostIDs,
+		).Reason(errActionNotAllowed)
+	}
+
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+
+	return false
+}
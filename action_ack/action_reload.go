@@ -0,0 +1,235 @@
+package main
+
+import (
+	stdcontext "context"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+// configReloadDelay - editors and config management write the file
+// by several operations, so reload waits until they are finished
+const configReloadDelay = time.Second
+
+// serviceState - config and clients which are created from it, it's
+// replaced as a whole when config is reloaded, so every request sees
+// consistent settings
+type serviceState struct {
+	config *config
+	zabbix *zabbix.Client
+	users  *zabbixUsers
+}
+
+// newServiceState - create clients from config, Zabbix client of
+// previous state is kept if settings of Zabbix are not changed, so
+// its session, detected version and tokens of users are not lost
+func newServiceState(
+	config *config,
+	previous *serviceState,
+) (*serviceState, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets.RegisterConfig(config)
+
+	var zabbixClient *zabbix.Client
+
+	if previous != nil &&
+		reflect.DeepEqual(previous.config.Zabbix, config.Zabbix) {
+		zabbixClient = previous.zabbix
+	} else {
+		zabbixClient, err = zabbix.NewClient(config.Zabbix)
+		if err != nil {
+			return nil, karma.Format(err, "can't create Zabbix client")
+		}
+	}
+
+	var previousUsers *zabbixUsers
+	if previous != nil {
+		previousUsers = previous.users
+	}
+
+	users, err := newZabbixUsers(config.Users, zabbixClient, previousUsers)
+	if err != nil {
+		return nil, karma.Format(err, "can't setup mapping of users")
+	}
+
+	return &serviceState{
+		config: config,
+		zabbix: zabbixClient,
+		users:  users,
+	}, nil
+}
+
+func (service *actionACKService) getState() *serviceState {
+	return service.state.Load().(*serviceState)
+}
+
+// getConfig - current config, it should be requested once per
+// request, so the request isn't affected by reload in the middle
+func (service *actionACKService) getConfig() *config {
+	return service.getState().config
+}
+
+func (service *actionACKService) getZabbix() *zabbix.Client {
+	return service.getState().zabbix
+}
+
+func (service *actionACKService) getUsers() *zabbixUsers {
+	return service.getState().users
+}
+
+// reload - read config file again and replace current state with
+// the new one, invalid config is rejected and current one is kept
+func (service *actionACKService) reload() error {
+	service.reloadMutex.Lock()
+	defer service.reloadMutex.Unlock()
+
+	destiny := karma.Describe(
+		"method", "reload",
+	).Describe(
+		"config", service.configFile,
+	)
+
+	config, err := loadConfig(service.configFile)
+	if err != nil {
+		return destiny.Reason(err)
+	}
+
+	current := service.getState()
+
+	keepRestartSettings(config, current.config)
+
+	state, err := newServiceState(config, current)
+	if err != nil {
+		return destiny.Reason(err)
+	}
+
+	err = transport.Setup(config.HTTP)
+	if err != nil {
+		return destiny.Format(err, "can't setup HTTP client")
+	}
+
+	if config.Audit.Path != current.config.Audit.Path {
+		err = service.auditLog.reopen(config.Audit.Path)
+		if err != nil {
+			return destiny.Reason(err)
+		}
+	}
+
+	service.state.Store(state)
+
+	if state.zabbix != current.zabbix {
+		go func() {
+			ctx, cancel := stdcontext.WithTimeout(
+				stdcontext.Background(),
+				logoutTimeout,
+			)
+			defer cancel()
+
+			err := current.zabbix.Logout(ctx)
+			if err != nil {
+				service.logger.Error(err)
+			}
+		}()
+	}
+
+	service.logger.Infof("config %s is reloaded", service.configFile)
+
+	return nil
+}
+
+// keepRestartSettings - copy settings which are applied only on
+// start from current config to the new one
+func keepRestartSettings(config *config, current *config) {
+	keep := func(name string, value interface{}, currentValue interface{}) {
+		if !reflect.DeepEqual(value, currentValue) {
+			logger.Warningf(
+				"%s can't be changed without restart, current value is kept",
+				name,
+			)
+		}
+	}
+
+	keep("listen_address", config.ListenAddress, current.ListenAddress)
+	config.ListenAddress = current.ListenAddress
+
+	keep("messengers", config.getMessengers(), current.getMessengers())
+	config.Messengers = current.Messengers
+
+	keep("server", config.Server, current.Server)
+	config.Server = current.Server
+
+	keep("events.state_file", config.Events.StateFile, current.Events.StateFile)
+	config.Events.StateFile = current.Events.StateFile
+}
+
+// watchConfig - reload config when its file is changed, directory
+// is watched because file is often replaced instead of being written,
+// e.g. by editors or by Kubernetes which swaps ..data symlink
+func (service *actionACKService) watchConfig(ctx stdcontext.Context) {
+	destiny := karma.Describe(
+		"method", "watchConfig",
+	).Describe(
+		"config", service.configFile,
+	)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		service.logger.Error(destiny.Format(err, "can't watch config"))
+		return
+	}
+
+	defer watcher.Close()
+
+	configFile := filepath.Clean(service.configFile)
+
+	err = watcher.Add(filepath.Dir(configFile))
+	if err != nil {
+		service.logger.Error(destiny.Format(err, "can't watch config"))
+		return
+	}
+
+	timer := time.NewTimer(configReloadDelay)
+	timer.Stop()
+
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			if filepath.Clean(event.Name) != configFile &&
+				filepath.Base(event.Name) != "..data" {
+				continue
+			}
+
+			timer.Reset(configReloadDelay)
+
+		case err := <-watcher.Errors:
+			service.logger.Error(destiny.Reason(err))
+
+		case <-timer.C:
+			err := service.reload()
+			if err != nil {
+				service.logger.Error(
+					karma.Format(err, "config is rejected, current one is kept"),
+				)
+			}
+		}
+	}
+}
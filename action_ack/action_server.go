@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	// defaultWriteTimeout - action waits for Zabbix and chat,
+	// so it's longer than timeout of HTTP client
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultTLSReloadInterval = time.Minute
+)
+
+// serverConfig - settings of HTTP server of chattixd, durations are
+// written in format of time.ParseDuration, e.g. "10s"
+type serverConfig struct {
+	ReadHeaderTimeout string `toml:"read_header_timeout"`
+	ReadTimeout       string `toml:"read_timeout"`
+	WriteTimeout      string `toml:"write_timeout"`
+	IdleTimeout       string `toml:"idle_timeout"`
+	// ShutdownTimeout - how long actions in progress are waited
+	// for when chattixd is stopped
+	ShutdownTimeout string `toml:"shutdown_timeout"`
+	// TLSCertFile and TLSKeyFile - HTTPS is served if both are set,
+	// files are re-read when they are changed
+	TLSCertFile       string `toml:"tls_cert_file"`
+	TLSKeyFile        string `toml:"tls_key_file"`
+	TLSReloadInterval string `toml:"tls_reload_interval"`
+}
+
+func (config serverConfig) getShutdownTimeout() (time.Duration, error) {
+	return parseServerDuration(
+		"shutdown_timeout",
+		config.ShutdownTimeout,
+		defaultShutdownTimeout,
+	)
+}
+
+// newHTTPServer - create HTTP server with timeouts from config,
+// TLS config is set if certificate is configured
+func newHTTPServer(
+	config serverConfig,
+	address string,
+	handler http.Handler,
+) (*http.Server, error) {
+	readHeaderTimeout, err := parseServerDuration(
+		"read_header_timeout",
+		config.ReadHeaderTimeout,
+		defaultReadHeaderTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	readTimeout, err := parseServerDuration(
+		"read_timeout", config.ReadTimeout, defaultReadTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	writeTimeout, err := parseServerDuration(
+		"write_timeout", config.WriteTimeout, defaultWriteTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	idleTimeout, err := parseServerDuration(
+		"idle_timeout", config.IdleTimeout, defaultIdleTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return server, nil
+	}
+
+	reloadInterval, err := parseServerDuration(
+		"tls_reload_interval",
+		config.TLSReloadInterval,
+		defaultTLSReloadInterval,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := newCertificateReloader(
+		config.TLSCertFile,
+		config.TLSKeyFile,
+		reloadInterval,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certificate.getCertificate,
+	}
+
+	return server, nil
+}
+
+// certificateReloader - TLS certificate which is re-read when its
+// files are changed, so renewed certificate is served without restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+	checkedAt   time.Time
+}
+
+func newCertificateReloader(
+	certFile string,
+	keyFile string,
+	interval time.Duration,
+) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+
+	modTimes, err := reloader.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	err = reloader.load(modTimes)
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *certificateReloader) stat() ([2]time.Time, error) {
+	modTimes := [2]time.Time{}
+
+	for i, path := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, karma.Describe(
+				"path", path,
+			).Format(err, "can't stat TLS file")
+		}
+
+		modTimes[i] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+func (reloader *certificateReloader) load(modTimes [2]time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return karma.Describe(
+			"cert", reloader.certFile,
+		).Describe(
+			"key", reloader.keyFile,
+		).Format(err, "can't load TLS certificate")
+	}
+
+	reloader.certificate = &certificate
+	reloader.modTimes = modTimes
+	reloader.checkedAt = time.Now()
+
+	return nil
+}
+
+// getCertificate - return current certificate, files are checked
+// once per interval and previous certificate is kept if new one
+// can't be loaded
+func (reloader *certificateReloader) getCertificate(
+	*tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	if time.Since(reloader.checkedAt) < reloader.interval {
+		return reloader.certificate, nil
+	}
+
+	reloader.checkedAt = time.Now()
+
+	modTimes, err := reloader.stat()
+	if err != nil {
+		logger.Error(err)
+		return reloader.certificate, nil
+	}
+
+	if modTimes == reloader.modTimes {
+		return reloader.certificate, nil
+	}
+
+	err = reloader.load(modTimes)
+	if err != nil {
+		logger.Error(err)
+		return reloader.certificate, nil
+	}
+
+	logger.Infof("TLS certificate %s is reloaded", reloader.certFile)
+
+	return reloader.certificate, nil
+}
+
+func parseServerDuration(
+	name string,
+	value string,
+	defaultValue time.Duration,
+) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, karma.Describe(
+			name, value,
+		).Reason(err)
+	}
+
+	return duration, nil
+}
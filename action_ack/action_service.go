@@ -1,14 +1,24 @@
 package main
 
 import (
+	stdcontext "context"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kovetskiy/lorg"
 	karma "github.com/reconquest/karma-go"
 	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/events"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/zabbix"
 )
 
 const (
@@ -16,46 +26,291 @@ const (
 	usernamePlaceholder = "{{USERNAME}}"
 	messengerSlack      = "slack"
 	messengerMattermost = "mattermost"
+	messengerMatrix     = "matrix"
+
+	slackPath      = "/slack"
+	mattermostPath = "/mattermost"
+	actionsPath    = "/actions"
+	dialogPath     = "/dialog"
+	commandPath    = "/command"
+	// legacyActionsPath - actions of the first messenger are still
+	// served without prefix, as they were before several
+	// messengers could be served
+	legacyActionsPath = "/"
+
+	defaultAction     = "ACK"
+	defaultActionType = "button"
+	defaultActionTTL  = 7 * 24 * time.Hour
+
+	slackViewSubmission = "view_submission"
+
+	logoutTimeout = 10 * time.Second
 )
 
 type actionACKService struct {
-	config        *config
-	gin           *gin.Engine
-	logger        *lorg.Log
-	messengerType string
+	// state - *serviceState, it's replaced when config is reloaded
+	state       atomic.Value
+	configFile  string
+	reloadMutex sync.Mutex
+
+	gin              *gin.Engine
+	logger           *lorg.Log
+	messengers       []string
+	auditLog         *auditLog
+	slackPendingAcks *slackPendingAcks
+	readiness        *readinessCache
+	eventLocks       *keyLocks
+	acknowledgers    *acknowledgers
+	events           *eventStore
+	// stateKey - random key which signs state passed through
+	// chat when no secret is shared with webhook
+	stateKey []byte
+
+	server          *http.Server
+	shutdownTimeout time.Duration
+	// stop - cancels background workers, e.g. Matrix sync
+	stop    stdcontext.CancelFunc
+	stopped stdcontext.Context
+	workers sync.WaitGroup
+	// done - closed when shutdown is finished
+	done chan struct{}
 }
 
 func newActionACKService(
-	config *config,
+	configFile string,
+	state *serviceState,
 	logger *lorg.Log,
-	messengerType string,
-) *actionACKService {
+	auditLog *auditLog,
+	eventStore *eventStore,
+) (*actionACKService, error) {
+	config := state.config
+
+	shutdownTimeout, err := config.Server.getShutdownTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	stateKey := make([]byte, 32)
+
+	_, err = rand.Read(stateKey)
+	if err != nil {
+		return nil, karma.Format(err, "can't generate state key")
+	}
+
+	stopped, stop := stdcontext.WithCancel(stdcontext.Background())
 
 	service := &actionACKService{
-		config:        config,
-		gin:           gin.Default(),
-		logger:        logger,
-		messengerType: messengerType,
+		configFile:       configFile,
+		gin:              gin.Default(),
+		logger:           logger,
+		messengers:       config.getMessengers(),
+		auditLog:         auditLog,
+		slackPendingAcks: newSlackPendingAcks(),
+		readiness:        newReadinessCache(),
+		eventLocks:       newKeyLocks(),
+		acknowledgers:    newAcknowledgers(),
+		events:           eventStore,
+		stateKey:         stateKey,
+		shutdownTimeout:  shutdownTimeout,
+		stop:             stop,
+		stopped:          stopped,
+		done:             make(chan struct{}),
+	}
+
+	service.state.Store(state)
+
+	service.server, err = newHTTPServer(
+		config.Server,
+		config.ListenAddress,
+		service.gin,
+	)
+	if err != nil {
+		stop()
+		return nil, karma.Format(err, "can't setup HTTP server")
 	}
 
-	return service
+	return service, nil
 }
 
 func (service *actionACKService) setRoute() {
-	if service.messengerType == messengerSlack {
-		service.gin.POST("/", service.handleACKSlack)
-		service.gin.GET("/", service.handleACKSlack)
+	service.setMetrics()
+
+	service.gin.GET(healthzPath, service.handleHealthz)
+	service.gin.GET(readyzPath, service.handleReadyz)
+
+	service.gin.GET(auditPath, service.handleAudit)
+
+	service.gin.POST(events.Path, service.handleEvents)
+
+	legacy := ""
+
+	for _, messenger := range service.messengers {
+		switch messenger {
+		case messengerSlack:
+			service.setSlackRoute(
+				service.gin.Group(slackPath, service.verifySlackRequest),
+				actionsPath,
+			)
+		case messengerMattermost:
+			service.setMattermostRoute(
+				service.gin.Group(mattermostPath),
+				actionsPath,
+			)
+		default:
+			continue
+		}
+
+		if legacy == "" {
+			legacy = messenger
+		}
+	}
+
+	switch legacy {
+	case messengerSlack:
+		service.setSlackRoute(
+			service.gin.Group("/", service.verifySlackRequest),
+			legacyActionsPath,
+		)
+	case messengerMattermost:
+		service.setMattermostRoute(
+			service.gin.Group("/"),
+			legacyActionsPath,
+		)
 	}
+}
+
+func (service *actionACKService) setSlackRoute(
+	group *gin.RouterGroup,
+	actionsPath string,
+) {
+	group.POST(actionsPath, service.handleACKSlack)
+	group.GET(actionsPath, service.handleACKSlack)
+	group.POST(commandPath, service.handleCommandSlack)
+}
+
+func (service *actionACKService) setMattermostRoute(
+	group *gin.RouterGroup,
+	actionsPath string,
+) {
+	group.POST(actionsPath, service.handleACKMattermost)
+	group.GET(actionsPath, service.handleACKMattermost)
+	group.POST(dialogPath, service.handleDialogMattermost)
+	group.POST(commandPath, service.handleCommandMattermost)
+}
 
-	if service.messengerType == messengerMattermost {
-		service.gin.POST("/", service.handleACKMattermost)
-		service.gin.GET("/", service.handleACKMattermost)
+// hasMessenger - whether messenger is served by this instance
+func (service *actionACKService) hasMessenger(messenger string) bool {
+	for _, served := range service.messengers {
+		if served == messenger {
+			return true
+		}
 	}
+
+	return false
 }
 
-func (service *actionACKService) run() {
+// run - serve requests until shutdown is finished
+func (service *actionACKService) run() error {
+	// Matrix has no interactive callbacks, reactions are received
+	// through /sync instead
+	if service.hasMessenger(messengerMatrix) {
+		service.workers.Add(1)
+
+		go func() {
+			defer service.workers.Done()
+
+			service.watchMatrix(service.stopped)
+		}()
+	}
+
+	// alerts which are registered by webhook are checked
+	// in Zabbix and escalated if nobody acknowledges them
+	service.workers.Add(1)
+
+	go func() {
+		defer service.workers.Done()
+
+		service.watchEvents(service.stopped)
+	}()
+
+	if service.getConfig().WatchConfig {
+		service.workers.Add(1)
+
+		go func() {
+			defer service.workers.Done()
+
+			service.watchConfig(service.stopped)
+		}()
+	}
+
 	service.setRoute()
-	service.gin.Run(service.config.ListenAddress)
+
+	var err error
+	if service.server.TLSConfig != nil {
+		err = service.server.ListenAndServeTLS("", "")
+	} else {
+		err = service.server.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	<-service.done
+
+	return nil
+}
+
+// shutdown - stop accepting requests and wait for actions in
+// progress, then release resources which outlive the process,
+// Zabbix session of logged in user is terminated
+func (service *actionACKService) shutdown() {
+	defer close(service.done)
+
+	ctx, cancel := stdcontext.WithTimeout(
+		stdcontext.Background(),
+		service.shutdownTimeout,
+	)
+	defer cancel()
+
+	err := service.server.Shutdown(ctx)
+	if err != nil {
+		service.logger.Error(
+			karma.Format(err, "can't wait for requests in progress"),
+		)
+	}
+
+	service.stop()
+
+	workersDone := make(chan struct{})
+	go func() {
+		service.workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		service.logger.Error("background workers are not stopped in time")
+	}
+
+	// Zabbix session is released even if shutdown timeout
+	// is already exceeded
+	logoutCtx, logoutCancel := stdcontext.WithTimeout(
+		stdcontext.Background(),
+		logoutTimeout,
+	)
+	defer logoutCancel()
+
+	err = service.getZabbix().Logout(logoutCtx)
+	if err != nil {
+		service.logger.Error(err)
+	}
+
+	err = service.auditLog.close()
+	if err != nil {
+		service.logger.Error(err)
+	}
 }
 
 func (service *actionACKService) handleACKSlack(
@@ -72,105 +327,313 @@ func (service *actionACKService) handleACKSlack(
 
 	err := json.Unmarshal([]byte(rawPayload), &payload)
 	if err != nil {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"error", err,
 			).Reason(
 				"can't unmarshal payload from Slack",
 			),
 		)
-		context.JSON(sendInternalServerError(destiny))
+		return
+	}
+
+	// Slack sends all interactions to the same URL
+	if payload.Type == slackViewSubmission {
+		service.handleModalSlack(context, &payload)
 		return
 	}
 
 	if len(payload.Actions) < 1 {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"count of actions", len(payload.Actions),
 			).Reason(
 				"request from Slack should contains an action",
 			),
 		)
-
-		context.JSON(
-			sendInternalServerError(destiny),
-		)
 		return
 
 	}
 
-	// EventID which send by webhook binary as action value
-	eventID := payload.Actions[0].Value.(string)
+	// context of action which send by webhook binary as action value
+	actionContext, err := parseSlackAction(payload.Actions[0])
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't decode action value from Slack",
+			),
+		)
+		return
+	}
 
-	messengerConfig := service.config.Messenger[service.messengerType]
+	messengerConfig := service.getConfig().Messenger[messengerSlack]
 
-	username, err := fetchUserFromSlack(
+	user, err := fetchUserFromSlack(
+		context.Request.Context(),
 		messengerConfig.MessengerAPIURL,
 		messengerConfig.MessengerAPIToken,
 		payload.User.ID,
 	)
 
 	if err != nil {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"error", err,
 			).Reason(
 				"can't fetch user from Slack",
 			),
 		)
-		context.JSON(sendInternalServerError(destiny))
 		return
 	}
 
-	newColor := messengerConfig.AttachmentsColor
+	err = service.authorize(
+		context.Request.Context(),
+		user,
+		payload.Channel.Name,
+		actionContext.Action,
+		actionContext.EventID,
+	)
+	if err != nil {
+		if karma.Contains(err, errActionNotAllowed) {
+			service.logger.Warning(err)
+
+			// original message is kept, answer is shown
+			// only to the user who pressed the button
+			context.JSON(http.StatusOK, map[string]interface{}{
+				"response_type":    "ephemeral",
+				"replace_original": false,
+				"text":             notAllowedText,
+			})
+			return
+		}
+
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't authorize action",
+			),
+		)
+		return
+	}
+
+	// comment and options are asked in modal for default
+	// action, acknowledgement is done on its submission
+	if messengerConfig.AckDialog && payload.TriggerID != "" &&
+		actionContext.ZabbixAction == 0 {
+		key := service.slackPendingAcks.add(&slackPendingAck{
+			EventID: actionContext.EventID,
+			UserID:  payload.User.ID,
+			Channel: payload.Channel.Name,
+			Ref: chat.PostRef{
+				Channel: payload.Channel.ID,
+				ID:      payload.MessageTS,
+			},
+			Message: payload.OriginalMessage,
+		})
+
+		err = openSlackModal(
+			context.Request.Context(),
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			payload.TriggerID,
+			key,
+		)
+		if err != nil {
+			service.sendInternalServerError(
+				context,
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't open modal in Slack",
+				),
+			)
+			return
+		}
+
+		// empty answer keeps the original message
+		context.Status(http.StatusOK)
+		return
+	}
 
 	authorMessage := strings.Replace(
 		messengerConfig.AuthorMessage,
 		usernamePlaceholder,
-		username,
+		user.Name,
 		-1,
 	)
 
 	message := payload.OriginalMessage
 	if len(message.Attachments) < 1 {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"count of attachment", len(message.Attachments),
 			).Reason(
 				"original message should contains an attachment",
 			),
 		)
+		return
+	}
+
+	acknowledgedBy, err := service.acknowledge(
+		context.Request.Context(),
+		user,
+		payload.Channel.Name,
+		actionContext.Action,
+		actionAcknowledgement(actionContext, authorMessage, user.Name),
+	)
+
+	if err != nil {
 
-		context.JSON(
-			sendInternalServerError(destiny),
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't acknowledge Zabbix event",
+			),
 		)
 		return
 	}
 
-	message.Attachments[0].Color = newColor
-	message.Attachments[0].Title = acknowledgedStatus
-	message.Attachments[0].Actions = []*chat.SlackAction{}
+	// message tells who has acknowledged the event first
+	acknowledgeSlackMessage(
+		message,
+		messengerConfig,
+		formatAuthorMessage(messengerConfig, acknowledgedBy),
+		actionContext.ZabbixAction,
+		actionSubmission(actionContext),
+	)
+
+	context.JSON(http.StatusOK, message)
+
+}
+
+// handleModalSlack - handle submission of acknowledgement modal,
+// the original message is updated through Slack API because
+// modal submission can't update it in response
+func (service *actionACKService) handleModalSlack(
+	context *gin.Context,
+	payload *slackActionRequest,
+) {
+	destiny := karma.Describe(
+		"method", "handleModalSlack",
+	)
+
+	if payload.View == nil || payload.View.CallbackID != dialogCallbackID {
+		context.Status(http.StatusOK)
+		return
+	}
+
+	pending := service.slackPendingAcks.get(payload.View.PrivateMetadata)
+	if pending == nil {
+		service.logger.Warningf(
+			"modal %s was submitted for unknown or expired alert",
+			payload.View.ID,
+		)
 
-	zabbixAttachment := &chat.SlackAttachment{
-		AuthorName: authorMessage,
-		AuthorIcon: messengerConfig.AuthorImageURL,
-		Color:      newColor,
+		context.JSON(http.StatusOK, slackModalError(
+			"Alert was not found, please press ACK again",
+		))
+		return
 	}
 
-	message.Attachments = append(
-		message.Attachments,
-		zabbixAttachment,
+	destiny = destiny.Describe(
+		"eventID", pending.EventID,
 	)
 
-	err = acknowledgeZabbixEvent(
-		service.config.Zabbix.ZabbixAPIURL,
-		service.config.Zabbix.ZabbixAPIToken,
-		eventID,
-		authorMessage,
+	// modal is authorized for the user who has opened it
+	if payload.User.ID != pending.UserID {
+		service.logger.Warning(
+			destiny.Describe(
+				"user", payload.User.ID,
+			).Describe(
+				"opened by", pending.UserID,
+			).Reason(
+				"modal is submitted by another user",
+			),
+		)
+
+		context.JSON(http.StatusOK, slackModalError(
+			"This modal was opened by another user",
+		))
+		return
+	}
+
+	messengerConfig := service.getConfig().Messenger[messengerSlack]
+
+	user, err := fetchUserFromSlack(
+		context.Request.Context(),
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		payload.User.ID,
 	)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't fetch user from Slack",
+			),
+		)
+		return
+	}
 
+	// policy or permissions could be changed while modal was opened
+	err = service.authorize(
+		context.Request.Context(),
+		user,
+		pending.Channel,
+		defaultAction,
+		pending.EventID,
+	)
 	if err != nil {
+		if karma.Contains(err, errActionNotAllowed) {
+			service.logger.Warning(err)
 
+			service.slackPendingAcks.remove(payload.View.PrivateMetadata)
+
+			context.JSON(http.StatusOK, slackModalError(notAllowedText))
+			return
+		}
+
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't authorize action",
+			),
+		)
+		return
+	}
+
+	authorMessage := strings.Replace(
+		messengerConfig.AuthorMessage,
+		usernamePlaceholder,
+		user.Name,
+		-1,
+	)
+
+	submission := payload.View.submission()
+
+	acknowledgedBy, err := service.acknowledge(
+		context.Request.Context(),
+		user,
+		pending.Channel,
+		defaultAction,
+		submission.acknowledgement(pending.EventID, authorMessage),
+	)
+	if err != nil {
 		service.logger.Error(
 			destiny.Describe(
 				"error", err,
@@ -179,12 +642,56 @@ func (service *actionACKService) handleACKSlack(
 			),
 		)
 
-		context.JSON(sendInternalServerError(destiny))
+		context.JSON(http.StatusOK, slackModalError(
+			"Can't acknowledge Zabbix event",
+		))
 		return
 	}
 
-	context.JSON(http.StatusOK, message)
+	service.slackPendingAcks.remove(payload.View.PrivateMetadata)
+
+	message := pending.Message
+	if message == nil || len(message.Attachments) < 1 {
+		context.Status(http.StatusOK)
+		return
+	}
+
+	acknowledgeSlackMessage(
+		message,
+		messengerConfig,
+		formatAuthorMessage(messengerConfig, acknowledgedBy),
+		0,
+		submission,
+	)
+
+	err = message.Update(
+		context.Request.Context(),
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		pending.Ref,
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't update message in Slack",
+			),
+		)
+	}
+
+	context.Status(http.StatusOK)
+}
 
+// slackModalError - answer which keeps modal opened and
+// shows error under comment
+func slackModalError(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"response_action": "errors",
+		"errors": map[string]string{
+			dialogCommentField: text,
+		},
+	}
 }
 
 func (service *actionACKService) handleACKMattermost(
@@ -198,71 +705,152 @@ func (service *actionACKService) handleACKMattermost(
 
 	err := json.NewDecoder(context.Request.Body).Decode(&request)
 	if err != nil {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"error", err,
 			).Reason(
 				"can't unmarshal payload from Mattermost",
 			),
 		)
-		context.JSON(sendInternalServerError(destiny))
 		return
 	}
 
-	messengerConfig := service.config.Messenger[service.messengerType]
+	messengerConfig := service.getConfig().Messenger[messengerMattermost]
+
+	err = service.verifyActionContext(messengerMattermost, &request.Context)
+	if err != nil {
+		service.logger.Warning(
+			destiny.Describe(
+				"user", request.UserID,
+			).Describe(
+				"eventID", request.Context.EventID,
+			).Describe(
+				"error", err,
+			).Reason(
+				"action context failed verification",
+			),
+		)
+
+		context.JSON(http.StatusForbidden, map[string]interface{}{
+			"ephemeral_text": "This action is expired or invalid",
+		})
+		return
+	}
 
-	username, err := fetchUserFromMattermost(
+	user, err := fetchUserFromMattermost(
+		context.Request.Context(),
 		messengerConfig.MessengerAPIURL,
 		messengerConfig.MessengerAPIToken,
 		request.UserID,
 	)
 	if err != nil {
-		service.logger.Error(
+		service.sendInternalServerError(
+			context,
 			destiny.Describe(
 				"error", err,
 			).Reason(
 				"can't fetch user from Mattermost",
 			),
 		)
-		context.JSON(sendInternalServerError(destiny))
 		return
 	}
 
-	mattermostMessage := chat.MattermostMessage{
-		ChannelName: request.Context.Channel,
-		Username:    request.Context.Username,
-		IconURL:     request.Context.IconURL,
-	}
+	err = service.authorize(
+		context.Request.Context(),
+		user,
+		request.Context.Channel,
+		request.Context.Action,
+		request.Context.EventID,
+	)
+	if err != nil {
+		if karma.Contains(err, errActionNotAllowed) {
+			service.logger.Warning(err)
+
+			context.JSON(http.StatusOK, map[string]interface{}{
+				"ephemeral_text": notAllowedText,
+			})
+			return
+		}
 
-	attachment := &chat.MattermostAttachment{
-		Color: messengerConfig.AttachmentsColor,
-		Text:  request.Context.Message,
-		Title: acknowledgedStatus,
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't authorize action",
+			),
+		)
+		return
 	}
 
-	attachment.AddField(
-		false,
-		"Event ID",
-		request.Context.EventID,
-	)
+	// comment and options are asked in dialog for default
+	// action, acknowledgement is done on its submission
+	if messengerConfig.AckDialog && request.TriggerID != "" &&
+		request.Context.ZabbixAction == 0 {
+		err = openMattermostDialog(
+			context.Request.Context(),
+			messengerConfig.MessengerAPIURL,
+			messengerConfig.MessengerAPIToken,
+			request.TriggerID,
+			messengerConfig.PublicURL+mattermostPath+dialogPath,
+			service.newMattermostDialogState(
+				request.PostID,
+				request.UserID,
+				request.Context,
+			),
+		)
+		if err != nil {
+			service.sendInternalServerError(
+				context,
+				destiny.Describe(
+					"error", err,
+				).Reason(
+					"can't open dialog in Mattermost",
+				),
+			)
+			return
+		}
+
+		context.JSON(http.StatusOK, map[string]interface{}{})
+		return
+	}
 
 	authorMessage := strings.Replace(
 		messengerConfig.AuthorMessage,
 		usernamePlaceholder,
-		username,
+		user.Name,
 		-1,
 	)
 
-	attachmentZabbix := &chat.MattermostAttachment{
-		Color:      messengerConfig.AttachmentsColor,
-		AuthorName: authorMessage,
-		AuthorIcon: messengerConfig.AuthorImageURL,
+	acknowledgedBy, err := service.acknowledge(
+		context.Request.Context(),
+		user,
+		request.Context.Channel,
+		request.Context.Action,
+		actionAcknowledgement(&request.Context, authorMessage, user.Name),
+	)
+
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't acknowledge Zabbix event",
+			),
+		)
+		return
 	}
 
-	mattermostMessage.Attachments = append(
-		mattermostMessage.Attachments,
-		attachment,
-		attachmentZabbix,
+	// post tells who has acknowledged the event first
+	authorMessage = formatAuthorMessage(messengerConfig, acknowledgedBy)
+
+	mattermostMessage := newMattermostAcknowledgedMessage(
+		request.Context,
+		messengerConfig,
+		authorMessage,
+		actionSubmission(&request.Context),
 	)
 
 	response := map[string]interface{}{
@@ -271,13 +859,176 @@ func (service *actionACKService) handleACKMattermost(
 		},
 	}
 
-	err = acknowledgeZabbixEvent(
-		service.config.Zabbix.ZabbixAPIURL,
-		service.config.Zabbix.ZabbixAPIToken,
-		request.Context.EventID,
-		authorMessage,
+	// post keeps its buttons if alert is not resolved by action
+	if !isResolvingAction(request.Context.ZabbixAction) {
+		response = map[string]interface{}{
+			"ephemeral_text": fmt.Sprintf(
+				"%s: %s",
+				getActionStatus(request.Context.ZabbixAction),
+				authorMessage,
+			),
+		}
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// handleDialogMattermost - handle submission of acknowledgement dialog,
+// the original post is updated through Mattermost API because
+// dialog submission can't update it in response
+func (service *actionACKService) handleDialogMattermost(
+	context *gin.Context,
+) {
+	destiny := karma.Describe(
+		"method", "handleDialogMattermost",
+	)
+
+	var request mattermostDialogRequest
+
+	err := json.NewDecoder(context.Request.Body).Decode(&request)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't unmarshal dialog submission from Mattermost",
+			),
+		)
+		return
+	}
+
+	if request.Cancelled {
+		context.JSON(http.StatusOK, map[string]interface{}{})
+		return
+	}
+
+	var state mattermostDialogState
+
+	err = json.Unmarshal([]byte(request.State), &state)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't unmarshal dialog state",
+			),
+		)
+		return
+	}
+
+	destiny = destiny.Describe(
+		"eventID", state.Context.EventID,
 	)
 
+	// dialog state is passed through Mattermost as is, so
+	// both state and its action context are checked
+	err = state.verify(service.dialogStateKey(), time.Now())
+	if err == nil {
+		err = service.verifyActionContext(messengerMattermost, &state.Context)
+	}
+
+	if err != nil {
+		service.logger.Warning(
+			destiny.Describe(
+				"user", request.UserID,
+			).Describe(
+				"error", err,
+			).Reason(
+				"dialog state failed verification",
+			),
+		)
+
+		context.JSON(http.StatusOK, map[string]interface{}{
+			"error": "This action is expired or invalid",
+		})
+		return
+	}
+
+	// dialog is authorized for the user who has opened it
+	if request.UserID != state.UserID {
+		service.logger.Warning(
+			destiny.Describe(
+				"user", request.UserID,
+			).Describe(
+				"opened by", state.UserID,
+			).Reason(
+				"dialog is submitted by another user",
+			),
+		)
+
+		context.JSON(http.StatusOK, map[string]interface{}{
+			"error": "This dialog was opened by another user",
+		})
+		return
+	}
+
+	messengerConfig := service.getConfig().Messenger[messengerMattermost]
+
+	user, err := fetchUserFromMattermost(
+		context.Request.Context(),
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		request.UserID,
+	)
+	if err != nil {
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't fetch user from Mattermost",
+			),
+		)
+		return
+	}
+
+	// policy or permissions could be changed while dialog was opened
+	err = service.authorize(
+		context.Request.Context(),
+		user,
+		state.Context.Channel,
+		state.Context.Action,
+		state.Context.EventID,
+	)
+	if err != nil {
+		if karma.Contains(err, errActionNotAllowed) {
+			service.logger.Warning(err)
+
+			context.JSON(http.StatusOK, map[string]interface{}{
+				"error": notAllowedText,
+			})
+			return
+		}
+
+		service.sendInternalServerError(
+			context,
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't authorize action",
+			),
+		)
+		return
+	}
+
+	authorMessage := strings.Replace(
+		messengerConfig.AuthorMessage,
+		usernamePlaceholder,
+		user.Name,
+		-1,
+	)
+
+	submission := request.submission()
+
+	acknowledgedBy, err := service.acknowledge(
+		context.Request.Context(),
+		user,
+		state.Context.Channel,
+		state.Context.Action,
+		submission.acknowledgement(state.Context.EventID, authorMessage),
+	)
 	if err != nil {
 		service.logger.Error(
 			destiny.Describe(
@@ -287,15 +1038,149 @@ func (service *actionACKService) handleACKMattermost(
 			),
 		)
 
-		context.JSON(sendInternalServerError(destiny))
+		context.JSON(http.StatusOK, map[string]interface{}{
+			"error": "can't acknowledge Zabbix event",
+		})
 		return
 	}
 
-	context.JSON(http.StatusOK, response)
+	mattermostMessage := newMattermostAcknowledgedMessage(
+		state.Context,
+		messengerConfig,
+		formatAuthorMessage(messengerConfig, acknowledgedBy),
+		submission,
+	)
+
+	err = mattermostMessage.Update(
+		context.Request.Context(),
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		chat.PostRef{
+			Channel: request.ChannelID,
+			ID:      state.PostID,
+		},
+	)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't update post in Mattermost",
+			),
+		)
+	}
+
+	context.JSON(http.StatusOK, map[string]interface{}{})
 }
 
-func sendInternalServerError(
-	responseData interface{},
-) (int, interface{}) {
-	return http.StatusInternalServerError, responseData
+// acknowledge - perform acknowledgement on behalf of the chat user
+// who has done the action. Already acknowledged event isn't
+// acknowledged again, only the rest of action is done, e.g. message
+// is added or problem is closed. Name of user who has acknowledged the event first is
+// returned, it's the chat user if the action is not acknowledgement.
+func (service *actionACKService) acknowledge(
+	ctx stdcontext.Context,
+	user *chatUser,
+	channel string,
+	action string,
+	acknowledgement zabbix.Acknowledgement,
+) (string, error) {
+	started := time.Now()
+
+	unlock := service.eventLocks.lock(acknowledgement.EventID)
+	defer unlock()
+
+	result := auditResultOK
+	acknowledgedBy := ""
+
+	client, err := service.getUsers().client(ctx, user)
+	if err == nil && isAcknowledgement(acknowledgement) {
+		acknowledgedBy, err = service.getAcknowledger(
+			ctx,
+			acknowledgement.EventID,
+		)
+	}
+
+	switch {
+	case err != nil:
+
+	case acknowledgedBy == "":
+		err = client.Acknowledge(ctx, acknowledgement)
+		if err == nil && isAcknowledgement(acknowledgement) {
+			service.acknowledgers.add(acknowledgement.EventID, user.Name)
+		}
+
+	default:
+		result = auditResultAlreadyAcknowledged
+
+		rest, ok := withoutAcknowledge(acknowledgement)
+		if ok {
+			err = client.Acknowledge(ctx, rest)
+		}
+	}
+
+	record := &auditRecord{
+		Time:         started,
+		Messenger:    user.Messenger,
+		UserID:       user.ID,
+		UserName:     user.Name,
+		UserLogin:    user.Login,
+		EventID:      acknowledgement.EventID,
+		Action:       action,
+		ZabbixAction: acknowledgement.Action,
+		Channel:      channel,
+		LatencyMS:    time.Since(started).Milliseconds(),
+		Result:       result,
+	}
+
+	if err != nil {
+		record.Result = auditResultError
+		record.Error = secrets.Redact(err.Error())
+	}
+
+	service.audit(record)
+
+	if acknowledgedBy == "" {
+		acknowledgedBy = user.Name
+	}
+
+	return acknowledgedBy, err
+}
+
+// verifyActionContext - check signature of action context, it's
+// skipped only if action_secret is not set and insecure_skip_verify
+// is set for the messenger, otherwise context is rejected
+func (service *actionACKService) verifyActionContext(
+	messenger string,
+	actionContext *context.ContextActionACK,
+) error {
+	messengerConfig := service.getConfig().Messenger[messenger]
+
+	if messengerConfig.ActionSecret == "" {
+		if messengerConfig.InsecureSkipVerify {
+			return nil
+		}
+
+		return karma.Describe(
+			"messenger", messenger,
+		).Reason(
+			"action_secret is not set, context can't be verified",
+		)
+	}
+
+	return actionContext.Verify(messengerConfig.ActionSecret, time.Now())
+}
+
+// signActionContext - sign action context which is created by
+// chattixd itself if the secret is configured
+func (service *actionACKService) signActionContext(
+	messenger string,
+	actionContext *context.ContextActionACK,
+) {
+	secret := service.getConfig().Messenger[messenger].ActionSecret
+	if secret == "" {
+		return
+	}
+
+	actionContext.Sign(secret, time.Now().Add(defaultActionTTL))
 }
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	karma "github.com/reconquest/karma-go"
 	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+)
+
+const (
+	slackPendingAckTTL = time.Hour
+
+	slackSignatureVersion = "v0"
+	// requests older than replay window are rejected even
+	// if signature is valid
+	slackReplayWindow = 5 * time.Minute
 )
 
 type slackActionRequest struct {
 	Type       string              `json:"type"`
 	Actions    []*chat.SlackAction `json:"actions"`
 	CallbackID string              `json:"callback_id"`
+	TriggerID  string              `json:"trigger_id"`
+	MessageTS  string              `json:"message_ts"`
 
 	Team struct {
 		ID     string `json:"id"`
@@ -30,28 +54,358 @@ type slackActionRequest struct {
 	} `json:"user"`
 
 	OriginalMessage *chat.SlackMessage `json:"original_message"`
+
+	View *slackView `json:"view"`
+}
+
+type slackView struct {
+	ID              string `json:"id"`
+	CallbackID      string `json:"callback_id"`
+	PrivateMetadata string `json:"private_metadata"`
+	State           struct {
+		Values map[string]map[string]*slackViewStateValue `json:"values"`
+	} `json:"state"`
+}
+
+type slackViewStateValue struct {
+	Type            string         `json:"type"`
+	Value           string         `json:"value"`
+	SelectedOption  *slackOption   `json:"selected_option"`
+	SelectedOptions []*slackOption `json:"selected_options"`
+}
+
+type slackOption struct {
+	Text  *slackText `json:"text"`
+	Value string     `json:"value"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackAPIResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// slackPendingAck - alert message for which acknowledgement modal
+// was opened by the user, it's updated when modal is submitted
+type slackPendingAck struct {
+	EventID  string
+	UserID   string
+	Channel  string
+	Ref      chat.PostRef
+	Message  *chat.SlackMessage
+	Deadline time.Time
+}
+
+// slackPendingAcks - alerts waiting for modal submission, key of
+// alert is passed through private metadata of the modal
+type slackPendingAcks struct {
+	mutex sync.Mutex
+	acks  map[string]*slackPendingAck
+}
+
+func newSlackPendingAcks() *slackPendingAcks {
+	return &slackPendingAcks{
+		acks: map[string]*slackPendingAck{},
+	}
+}
+
+func (pending *slackPendingAcks) add(ack *slackPendingAck) string {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	now := time.Now()
+	for key, ack := range pending.acks {
+		if now.After(ack.Deadline) {
+			delete(pending.acks, key)
+		}
+	}
+
+	ack.Deadline = now.Add(slackPendingAckTTL)
+
+	key := fmt.Sprintf("%s-%d", ack.Ref.ID, now.UnixNano())
+	pending.acks[key] = ack
+
+	return key
+}
+
+// len - number of modals waiting for submission, expired
+// ones are counted until the next add
+func (pending *slackPendingAcks) len() int {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	return len(pending.acks)
+}
+
+// get - alert waiting for submission, it's kept until it's removed,
+// so modal can be submitted again if acknowledgement is failed
+func (pending *slackPendingAcks) get(key string) *slackPendingAck {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	ack, ok := pending.acks[key]
+	if !ok || time.Now().After(ack.Deadline) {
+		return nil
+	}
+
+	return ack
+}
+
+func (pending *slackPendingAcks) remove(key string) {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	delete(pending.acks, key)
+}
+
+// submission - parse values filled by user in modal
+func (view *slackView) submission() dialogSubmission {
+	submission := dialogSubmission{}
+
+	value := func(name string) *slackViewStateValue {
+		if block, ok := view.State.Values[name]; ok {
+			return block[name]
+		}
+
+		return nil
+	}
+
+	if comment := value(dialogCommentField); comment != nil {
+		submission.Comment = strings.TrimSpace(comment.Value)
+	}
+
+	if closeProblem := value(dialogCloseField); closeProblem != nil {
+		submission.Close = len(closeProblem.SelectedOptions) > 0
+	}
+
+	severity := value(dialogSeverityField)
+	if severity != nil && severity.SelectedOption != nil {
+		submission.Severity = severity.SelectedOption.Value
+	}
+
+	return submission
+}
+
+func openSlackModal(
+	ctx context.Context,
+	chatAPIURL string,
+	chatAPIToken string,
+	triggerID string,
+	privateMetadata string,
+) error {
+	destiny := karma.Describe(
+		"method", "openSlackModal",
+	).Describe(
+		"url", secrets.Redact(chatAPIURL),
+	)
+
+	plainText := func(text string) *slackText {
+		return &slackText{Type: "plain_text", Text: text}
+	}
+
+	severityOptions := []*slackOption{}
+	for _, severity := range zabbixSeverities {
+		severityOptions = append(
+			severityOptions,
+			&slackOption{
+				Text:  plainText(severity.Name),
+				Value: severity.Value,
+			},
+		)
+	}
+
+	view := map[string]interface{}{
+		"type":             "modal",
+		"callback_id":      dialogCallbackID,
+		"private_metadata": privateMetadata,
+		"title":            plainText(dialogTitle),
+		"submit":           plainText(dialogSubmitLabel),
+		"close":            plainText("Cancel"),
+		"blocks": []map[string]interface{}{
+			{
+				"type":     "input",
+				"block_id": dialogCommentField,
+				"optional": true,
+				"label":    plainText("Comment"),
+				"element": map[string]interface{}{
+					"type":        "plain_text_input",
+					"action_id":   dialogCommentField,
+					"multiline":   true,
+					"placeholder": plainText("Reason, ETA"),
+				},
+			},
+			{
+				"type":     "input",
+				"block_id": dialogCloseField,
+				"optional": true,
+				"label":    plainText("Problem"),
+				"element": map[string]interface{}{
+					"type":      "checkboxes",
+					"action_id": dialogCloseField,
+					"options": []*slackOption{
+						{
+							Text:  plainText("Close problem"),
+							Value: dialogCloseField,
+						},
+					},
+				},
+			},
+			{
+				"type":     "input",
+				"block_id": dialogSeverityField,
+				"optional": true,
+				"label":    plainText("Severity"),
+				"hint":     plainText("Leave empty to keep current severity"),
+				"element": map[string]interface{}{
+					"type":        "static_select",
+					"action_id":   dialogSeverityField,
+					"placeholder": plainText("Keep current severity"),
+					"options":     severityOptions,
+				},
+			},
+		},
+	}
+
+	body := new(bytes.Buffer)
+
+	err := json.NewEncoder(body).Encode(map[string]interface{}{
+		"trigger_id": triggerID,
+		"view":       view,
+	})
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't marshal request payload")
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s/views.open", chatAPIURL),
+		body,
+	)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't create HTTP request",
+		)
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf("Bearer %s", chatAPIToken),
+	)
+
+	request.Header.Set(
+		"Content-Type",
+		"application/json; charset=utf-8",
+	)
+
+	response, err := doChatRequest(transport.Client(), request, messengerSlack)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't execute HTTP request",
+		)
+	}
+
+	defer response.Body.Close()
+
+	var answer slackAPIResponse
+
+	err = json.NewDecoder(response.Body).Decode(&answer)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't decode Slack response",
+		)
+	}
+
+	if !answer.Ok {
+		return destiny.Describe(
+			"error", answer.Error,
+		).Reason(
+			"Non ok anwer from Slack API",
+		)
+	}
+
+	return nil
+}
+
+// acknowledgeSlackMessage - change alert message after action, author
+// of action is added and buttons are removed if alert is resolved
+func acknowledgeSlackMessage(
+	message *chat.SlackMessage,
+	messengerConfig messengerConfig,
+	authorMessage string,
+	action int,
+	submission dialogSubmission,
+) {
+	newColor := messengerConfig.AttachmentsColor
+
+	zabbixAttachment := &chat.SlackAttachment{
+		AuthorName: authorMessage,
+		AuthorIcon: messengerConfig.AuthorImageURL,
+		Color:      newColor,
+		Text:       submission.Comment,
+	}
+
+	if isResolvingAction(action) {
+		message.Attachments[0].Color = newColor
+		message.Attachments[0].Title = getActionStatus(action)
+		message.Attachments[0].Actions = []*chat.SlackAction{}
+	} else {
+		zabbixAttachment.AddField(true, "Action", getActionStatus(action))
+	}
+
+	if submission.Close {
+		zabbixAttachment.AddField(true, "Problem", "Closed")
+	}
+
+	if submission.Severity != "" {
+		zabbixAttachment.AddField(
+			true,
+			"Severity",
+			getZabbixSeverityName(submission.Severity),
+		)
+	}
+
+	message.Attachments = append(
+		message.Attachments,
+		zabbixAttachment,
+	)
 }
 
 type slackUserResponse struct {
 	Ok   bool `json:"ok"`
 	User struct {
+		Name     string `json:"name"`
 		RealName string `json:"real_name"`
+		Profile  struct {
+			Email string `json:"email"`
+		} `json:"profile"`
 	} `json:"user"`
 	Error string `json:"error"`
 }
 
 func fetchUserFromSlack(
+	ctx context.Context,
 	chatAPIURL string,
 	chatAPIToken string,
 	userID string,
-) (string, error) {
+) (*chatUser, error) {
 
 	destiny := karma.Describe(
 		"method", "fetchUserFromSlack",
 	).Describe(
-		"url", chatAPIURL,
-	).Describe(
-		"authToken", chatAPIToken,
+		"url", secrets.Redact(chatAPIURL),
 	).Describe(
 		"userID", userID,
 	)
@@ -62,13 +416,14 @@ func fetchUserFromSlack(
 		userID,
 	)
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		requestURL,
 		nil,
 	)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"request url", requestURL,
 		).Describe(
 			"error", err,
@@ -87,10 +442,9 @@ func fetchUserFromSlack(
 		"application/x-www-form-urlencoded",
 	)
 
-	client := http.Client{}
-	response, err := client.Do(request)
+	response, err := doChatRequest(transport.Client(), request, messengerSlack)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason(
 			"can't execute HTTP request",
@@ -103,7 +457,7 @@ func fetchUserFromSlack(
 
 	err = json.NewDecoder(response.Body).Decode(&body)
 	if err != nil {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", err,
 		).Reason(
 			"can't decode Slack user response",
@@ -111,7 +465,7 @@ func fetchUserFromSlack(
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"status code", response.StatusCode,
 		).Describe(
 			"error", body.Error,
@@ -121,13 +475,115 @@ func fetchUserFromSlack(
 	}
 
 	if !body.Ok {
-		return "", destiny.Describe(
+		return nil, destiny.Describe(
 			"error", body.Error,
 		).Reason(
 			"Non ok anwer from Slack API",
 		)
 	}
 
-	return body.User.RealName, nil
+	return &chatUser{
+		Messenger: messengerSlack,
+		ID:        userID,
+		Name:      body.User.RealName,
+		Login:     body.User.Name,
+		Email:     body.User.Profile.Email,
+	}, nil
+
+}
+
+// verifySlackRequest - middleware which rejects requests which are
+// not signed by Slack with signing secret of the app
+func (service *actionACKService) verifySlackRequest(
+	context *gin.Context,
+) {
+	destiny := karma.Describe(
+		"method", "verifySlackRequest",
+	)
+
+	signingSecret := service.getConfig().Messenger[messengerSlack].SigningSecret
+	if signingSecret == "" {
+		context.Next()
+		return
+	}
+
+	body, err := ioutil.ReadAll(context.Request.Body)
+	if err != nil {
+		service.logger.Error(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"can't read request body",
+			),
+		)
+		context.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	context.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	err = checkSlackSignature(
+		signingSecret,
+		context.GetHeader("X-Slack-Request-Timestamp"),
+		context.GetHeader("X-Slack-Signature"),
+		body,
+		time.Now(),
+	)
+	if err != nil {
+		service.logger.Warning(
+			destiny.Describe(
+				"remote address", context.ClientIP(),
+			).Describe(
+				"error", err,
+			).Reason(
+				"request from Slack failed verification",
+			),
+		)
+
+		context.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			map[string]string{
+				"error": "invalid request signature",
+			},
+		)
+		return
+	}
+
+	context.Next()
+}
+
+// checkSlackSignature - verify signature of Slack request, see
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func checkSlackSignature(
+	signingSecret string,
+	timestamp string,
+	signature string,
+	body []byte,
+	now time.Time,
+) error {
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("request is not signed")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp %s", timestamp)
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > slackReplayWindow || age < -slackReplayWindow {
+		return fmt.Errorf("request timestamp %s is out of window", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "%s:%s:", slackSignatureVersion, timestamp)
+	mac.Write(body)
+
+	expected := slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
 
+	return nil
 }
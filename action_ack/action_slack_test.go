@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckSlackSignature(t *testing.T) {
+	// example of Slack documentation about verifying requests
+	const (
+		signingSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+		timestamp     = "1531420618"
+		signature     = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+		body          = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J" +
+			"&team_domain=testteamnow&channel_id=G8PSS9T3V" +
+			"&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner" +
+			"&command=%2Fwebhook-collect&text=" +
+			"&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands" +
+			"%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+			"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	)
+
+	signedAt := time.Unix(1531420618, 0)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      string
+		now       time.Time
+		valid     bool
+	}{
+		{
+			name:      "valid",
+			secret:    signingSecret,
+			timestamp: timestamp,
+			signature: signature,
+			body:      body,
+			now:       signedAt.Add(time.Minute),
+			valid:     true,
+		},
+		{
+			name:      "wrong signature",
+			secret:    signingSecret,
+			timestamp: timestamp,
+			signature: "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b504",
+			body:      body,
+			now:       signedAt,
+		},
+		{
+			name:      "wrong secret",
+			secret:    "another_secret",
+			timestamp: timestamp,
+			signature: signature,
+			body:      body,
+			now:       signedAt,
+		},
+		{
+			name:      "tampered body",
+			secret:    signingSecret,
+			timestamp: timestamp,
+			signature: signature,
+			body:      body + "&text=ack",
+			now:       signedAt,
+		},
+		{
+			name:      "stale timestamp",
+			secret:    signingSecret,
+			timestamp: timestamp,
+			signature: signature,
+			body:      body,
+			now:       signedAt.Add(slackReplayWindow + time.Second),
+		},
+		{
+			name:      "timestamp from future",
+			secret:    signingSecret,
+			timestamp: timestamp,
+			signature: signature,
+			body:      body,
+			now:       signedAt.Add(-slackReplayWindow - time.Second),
+		},
+		{
+			name:   "not signed",
+			secret: signingSecret,
+			body:   body,
+			now:    signedAt,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkSlackSignature(
+				test.secret,
+				test.timestamp,
+				test.signature,
+				[]byte(test.body),
+				test.now,
+			)
+
+			if test.valid && err != nil {
+				t.Errorf("valid signature is rejected: %s", err)
+			}
+
+			if !test.valid && err == nil {
+				t.Error("invalid signature is accepted")
+			}
+		})
+	}
+}
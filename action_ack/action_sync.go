@@ -0,0 +1,239 @@
+package main
+
+import (
+	stdcontext "context"
+	"encoding/json"
+
+	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+const (
+	resolvedStatus = "RESOLVED"
+
+	// resolvedTimeLayout - how time of recovery is shown in posts
+	resolvedTimeLayout = "2006-01-02 15:04:05 MST"
+
+	// zabbixAcknowledgerSuffix - shown after name of user who has
+	// acknowledged the event in Zabbix UI instead of chat
+	zabbixAcknowledgerSuffix = " in Zabbix"
+)
+
+// syncEvent - update the post when problem is acknowledged,
+// unacknowledged or resolved in Zabbix, true is returned if the
+// event shouldn't be tracked anymore
+func (service *actionACKService) syncEvent(
+	ctx stdcontext.Context,
+	event *trackedEvent,
+	state *zabbix.EventState,
+) bool {
+	changed := false
+
+	switch {
+	case state.IsAcknowledged() && event.AcknowledgedBy == "":
+		// post is already updated by chattixd if chat user has
+		// acknowledged the event
+		name := service.acknowledgers.get(
+			event.EventID,
+			state.Acknowledge.Time(),
+		)
+		if name == "" {
+			name = unknownAcknowledger
+			if state.Acknowledge.UserName() != "" {
+				name = state.Acknowledge.UserName() + zabbixAcknowledgerSuffix
+			}
+
+			changed = true
+		}
+
+		event.AcknowledgedBy = name
+
+	case !state.IsAcknowledged() && event.AcknowledgedBy != "":
+		event.AcknowledgedBy = ""
+		changed = true
+
+	case !state.IsResolved():
+		return false
+	}
+
+	if state.IsResolved() {
+		service.updatePost(ctx, event, state)
+		return true
+	}
+
+	if changed {
+		service.updatePost(ctx, event, state)
+	}
+
+	err := service.events.update(event)
+	if err != nil {
+		service.logger.Error(err)
+	}
+
+	return false
+}
+
+// updatePost - replace the post by the current state of problem,
+// posts made through incoming webhooks can't be updated
+func (service *actionACKService) updatePost(
+	ctx stdcontext.Context,
+	event *trackedEvent,
+	state *zabbix.EventState,
+) {
+	if event.Ref.IsEmpty() {
+		return
+	}
+
+	config := service.getConfig()
+	messengerConfig := config.Messenger[event.Messenger]
+
+	message := newSyncMessage(
+		event.Messenger,
+		messengerConfig,
+		config.Events,
+		event,
+		state,
+	)
+
+	err := message.Update(
+		ctx,
+		messengerConfig.MessengerAPIURL,
+		messengerConfig.MessengerAPIToken,
+		event.Ref,
+	)
+	if err != nil {
+		syncedPosts.WithLabelValues(event.Messenger, syncResultError).Inc()
+
+		service.logger.Error(
+			karma.Describe(
+				"method", "updatePost",
+			).Describe(
+				"eventID", event.EventID,
+			).Describe(
+				"messenger", event.Messenger,
+			).Describe(
+				"channel", event.Channel,
+			).Format(err, "can't update post of problem"),
+		)
+		return
+	}
+
+	syncedPosts.WithLabelValues(event.Messenger, syncResultOK).Inc()
+
+	service.logger.Infof(
+		"post of problem %s in %s %s is updated",
+		event.EventID,
+		event.Messenger,
+		event.Channel,
+	)
+}
+
+// newSyncMessage - alert which shows state of problem in Zabbix,
+// fields of the alert are kept and its buttons are shown again if
+// the problem is unacknowledged in Zabbix
+func newSyncMessage(
+	messenger string,
+	messengerConfig messengerConfig,
+	eventsConfig eventsConfig,
+	event *trackedEvent,
+	state *zabbix.EventState,
+) chat.Message {
+	status := unacknowledgedStatus
+	color := messengerConfig.AttachmentsColor
+
+	switch {
+	case state.IsClosedManually():
+		status = closedStatus
+	case state.IsResolved():
+		status = resolvedStatus
+	case event.AcknowledgedBy != "":
+		status = acknowledgedStatus
+	}
+
+	if state.IsResolved() {
+		color = eventsConfig.ResolvedColor
+		if color == "" {
+			color = defaultResolvedColor
+		}
+	}
+
+	message, attachment, restored := restorePost(messenger, event, color)
+	attachment.SetTitle(status)
+
+	// color and buttons of alert are kept until problem is
+	// acknowledged or resolved
+	if status != unacknowledgedStatus || !restored {
+		attachment.SetColor(color)
+		removeActions(message)
+	}
+
+	if event.AcknowledgedBy != "" {
+		attachment.AddField(true, "Acknowledged by", event.AcknowledgedBy)
+	}
+
+	if state.IsResolved() && !state.ResolvedAt.IsZero() {
+		attachment.AddField(
+			true,
+			"Resolved at",
+			state.ResolvedAt.Format(resolvedTimeLayout),
+		)
+	}
+
+	if state.IsClosedManually() {
+		closedBy := "manually"
+		if state.Close != nil && state.Close.UserName() != "" {
+			closedBy += " by " + state.Close.UserName()
+		}
+
+		attachment.AddField(true, "Closed", closedBy)
+	}
+
+	return message
+}
+
+// restorePost - alert as it was posted by webhook, bare alert is
+// made if webhook hasn't registered the post, false is returned then
+func restorePost(
+	messenger string,
+	event *trackedEvent,
+	color string,
+) (chat.Message, chat.MessageAttachment, bool) {
+	if len(event.Post) != 0 {
+		message := newMessage(messenger)
+
+		err := json.Unmarshal(event.Post, message)
+		if err == nil {
+			attachment, err := message.GetAttachment(0)
+			if err == nil {
+				return message, attachment, true
+			}
+		}
+	}
+
+	message := newMessage(messenger)
+
+	attachment := message.CreateAttachment(event.Message, color)
+	attachment.AddField(false, "Event ID", event.EventID)
+
+	return message, attachment, false
+}
+
+// removeActions - remove buttons of alert, Matrix alert shows
+// reactions of actions instead
+func removeActions(message chat.Message) {
+	switch message := message.(type) {
+	case *chat.SlackMessage:
+		for _, attachment := range message.Attachments {
+			attachment.Actions = []*chat.SlackAction{}
+		}
+	case *chat.MattermostMessage:
+		for _, attachment := range message.Attachments {
+			attachment.Actions = []*chat.MattermostAction{}
+		}
+	case *chat.MatrixMessage:
+		for _, attachment := range message.Attachments {
+			attachment.Actions = []*chat.MatrixAction{}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	stdcontext "context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kovetskiy/toml"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/zabbix"
+)
+
+// chatUser - user of chat who performs an action
+type chatUser struct {
+	// Messenger - chat where user has done the action
+	Messenger string
+	// ID - identifier of user in chat
+	ID string
+	// Name - full name which is shown in author message
+	Name  string
+	Login string
+	Email string
+}
+
+const (
+	matchUsersByEmail = "email"
+	matchUsersByLogin = "login"
+
+	// userTokenName - name of API tokens which are created
+	// for users by chattixd
+	userTokenName       = "chattix"
+	defaultUserTokenTTL = 24 * time.Hour
+)
+
+// errUserNotMapped - action can't be done on behalf of the chat user
+// and it's not allowed to do it by service account
+var errUserNotMapped = errors.New("chat user is not mapped to Zabbix user")
+
+// mappedUser - Zabbix user of chat user from mapping file
+type mappedUser struct {
+	ZabbixUser  string `toml:"zabbix_user"`
+	ZabbixToken string `toml:"zabbix_token"`
+}
+
+type usersMapping struct {
+	Users map[string]mappedUser `toml:"users"`
+}
+
+// userToken - API token which was created for Zabbix user
+type userToken struct {
+	token    string
+	deadline time.Time
+}
+
+// zabbixUsers - Zabbix users on behalf of which actions of chat
+// users are done, so Zabbix shows who has really done an action
+type zabbixUsers struct {
+	config   usersConfig
+	zabbix   *zabbix.Client
+	mapping  map[string]mappedUser
+	tokenTTL time.Duration
+
+	// tokenLocks - token of the same user is created once while
+	// tokens of other users are created at the same time
+	tokenLocks *keyLocks
+	mutex      sync.Mutex
+	tokens     map[string]*userToken
+}
+
+// newZabbixUsers - create mapping of users from config, tokens of
+// previous users are kept if they are created by the same Zabbix
+// client, so reload of config doesn't create tokens of all users again
+func newZabbixUsers(
+	config usersConfig,
+	zabbixClient *zabbix.Client,
+	previous *zabbixUsers,
+) (*zabbixUsers, error) {
+	users := &zabbixUsers{
+		config:     config,
+		zabbix:     zabbixClient,
+		mapping:    map[string]mappedUser{},
+		tokenTTL:   defaultUserTokenTTL,
+		tokenLocks: newKeyLocks(),
+		tokens:     map[string]*userToken{},
+	}
+
+	if previous != nil && previous.zabbix == zabbixClient {
+		users.tokenLocks = previous.tokenLocks
+
+		previous.mutex.Lock()
+		for userID, token := range previous.tokens {
+			users.tokens[userID] = token
+		}
+		previous.mutex.Unlock()
+	}
+
+	if config.TokenTTL != "" {
+		tokenTTL, err := time.ParseDuration(config.TokenTTL)
+		if err != nil {
+			return nil, karma.Describe(
+				"token_ttl", config.TokenTTL,
+			).Reason(err)
+		}
+
+		users.tokenTTL = tokenTTL
+	}
+
+	if config.MappingFile != "" {
+		var mapping usersMapping
+
+		_, err := toml.DecodeFile(config.MappingFile, &mapping)
+		if err != nil {
+			return nil, karma.Describe(
+				"mapping file", config.MappingFile,
+			).Format(err, "can't read users mapping file")
+		}
+
+		err = secrets.Resolve(&mapping)
+		if err != nil {
+			return nil, karma.Describe(
+				"mapping file", config.MappingFile,
+			).Format(err, "can't resolve users mapping file")
+		}
+
+		secrets.RegisterConfig(&mapping)
+
+		for key, user := range mapping.Users {
+			users.mapping[strings.ToLower(key)] = user
+		}
+	}
+
+	return users, nil
+}
+
+// client - Zabbix client which acts on behalf of chat user, client
+// of service account is returned if user can't be acted as and
+// mapping isn't required
+func (users *zabbixUsers) client(
+	ctx stdcontext.Context,
+	user *chatUser,
+) (*zabbix.Client, error) {
+	destiny := karma.Describe(
+		"method", "client",
+	).Describe(
+		"login", user.Login,
+	).Describe(
+		"email", user.Email,
+	)
+
+	client, err := users.userClient(ctx, user)
+	if err == nil {
+		return client, nil
+	}
+
+	reason := userLookupError
+	switch {
+	case karma.Contains(err, errUserNotMapped):
+		reason = userLookupNotMapped
+	case karma.Contains(err, zabbix.ErrUserNotFound):
+		reason = userLookupNotFound
+	}
+
+	lookupExpected := users.config.MatchBy != "" ||
+		len(users.mapping) > 0 ||
+		users.config.RequireMapping
+
+	if reason == userLookupError || lookupExpected {
+		userLookupFailures.WithLabelValues(reason).Inc()
+	}
+
+	if reason == userLookupError {
+		return nil, destiny.Reason(err)
+	}
+
+	if users.config.RequireMapping {
+		return nil, destiny.Reason(err)
+	}
+
+	if lookupExpected {
+		logger.Warning(
+			destiny.Describe(
+				"error", err,
+			).Reason(
+				"action is done by service account",
+			),
+		)
+	}
+
+	return users.zabbix, nil
+}
+
+func (users *zabbixUsers) userClient(
+	ctx stdcontext.Context,
+	user *chatUser,
+) (*zabbix.Client, error) {
+	login := ""
+
+	mapped, ok := users.lookupMapping(user)
+	if ok {
+		if mapped.ZabbixToken != "" {
+			return users.zabbix.WithToken(mapped.ZabbixToken), nil
+		}
+
+		login = mapped.ZabbixUser
+	}
+
+	var (
+		zabbixUser *zabbix.User
+		err        error
+	)
+
+	switch {
+	case login != "":
+		zabbixUser, err = users.zabbix.FindUserByLogin(ctx, login)
+	case users.config.MatchBy == matchUsersByLogin && user.Login != "":
+		zabbixUser, err = users.zabbix.FindUserByLogin(ctx, user.Login)
+	case users.config.MatchBy == matchUsersByEmail && user.Email != "":
+		zabbixUser, err = users.zabbix.FindUserByEmail(ctx, user.Email)
+	default:
+		return nil, errUserNotMapped
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// token of user can be created only by service account
+	if !users.config.Impersonate {
+		return nil, karma.Describe(
+			"zabbix user", zabbixUser.Login(),
+		).Reason(errUserNotMapped)
+	}
+
+	token, err := users.token(ctx, zabbixUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return users.zabbix.WithToken(token), nil
+}
+
+func (users *zabbixUsers) lookupMapping(user *chatUser) (mappedUser, bool) {
+	for _, key := range []string{user.Login, user.Email} {
+		if key == "" {
+			continue
+		}
+
+		mapped, ok := users.mapping[strings.ToLower(key)]
+		if ok {
+			return mapped, true
+		}
+	}
+
+	return mappedUser{}, false
+}
+
+// token - API token of Zabbix user, token is created by service
+// account and it's renewed before it expires
+func (users *zabbixUsers) token(
+	ctx stdcontext.Context,
+	zabbixUser *zabbix.User,
+) (string, error) {
+	unlock := users.tokenLocks.lock(zabbixUser.UserID)
+	defer unlock()
+
+	users.mutex.Lock()
+	cached, ok := users.tokens[zabbixUser.UserID]
+	users.mutex.Unlock()
+
+	if ok && time.Now().Before(cached.deadline) {
+		return cached.token, nil
+	}
+
+	expires := time.Now().Add(users.tokenTTL)
+
+	token, err := users.zabbix.CreateUserToken(
+		ctx,
+		zabbixUser.UserID,
+		userTokenName,
+		expires,
+	)
+	if err != nil {
+		return "", karma.Describe(
+			"zabbix user", zabbixUser.Login(),
+		).Format(err, "can't create API token of user")
+	}
+
+	secrets.Register(token)
+
+	users.mutex.Lock()
+	users.tokens[zabbixUser.UserID] = &userToken{
+		token: token,
+		// token is renewed in advance, so request
+		// doesn't fail because of expiration
+		deadline: expires.Add(-users.tokenTTL / 10),
+	}
+	users.mutex.Unlock()
+
+	return token, nil
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
+	"github.com/gin-gonic/gin"
 	"github.com/kovetskiy/lorg"
 	"github.com/kovetskiy/toml"
 	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
 )
 
 var (
@@ -17,10 +25,18 @@ var (
 
 Usage:
   chattixd [--config <path>]
+  chattixd audit [--config <path>] [--event <id>] [--user <user>]
+                 [--channel <channel>] [--since <time>] [--until <time>]
 
 Options:
     -c --config <path>  Path to config file 
                          [default: /etc/chattix/chattixd.conf]
+    --event <id>        Show actions with Zabbix event.
+    --user <user>       Show actions of chat user, ID, login or name.
+    --channel <channel> Show actions in channel.
+    --since <time>      Show actions since time in RFC3339 format
+                         or duration back from now, e.g. 24h.
+    --until <time>      Show actions until time, format is the same.
                                                                                
 `
 )
@@ -33,7 +49,12 @@ func main() {
 	)
 
 	logger = lorg.NewLog()
-	conf := &config{}
+
+	// tokens and passwords are masked in every log record,
+	// including requests which are logged by gin
+	logger.SetOutput(secrets.NewWriter(os.Stderr))
+	gin.DefaultWriter = secrets.NewWriter(os.Stdout)
+	gin.DefaultErrorWriter = secrets.NewWriter(os.Stderr)
 
 	args, err := docopt.Parse(usage, nil, true, version, false)
 	if err != nil {
@@ -42,16 +63,12 @@ func main() {
 
 	configFile := args["--config"].(string)
 
+	var conf *config
+
 	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
-		_, err = toml.DecodeFile(configFile, conf)
+		conf, err = loadConfig(configFile)
 		if err != nil {
-			logger.Fatal(
-				destiny.Format(
-					err,
-					"can't read config file %s",
-					configFile,
-				),
-			)
+			logger.Fatal(destiny.Reason(err))
 		}
 	} else {
 		logger.Infof(
@@ -59,6 +76,8 @@ func main() {
 			configFile,
 		)
 
+		conf = &config{}
+
 		_, err = toml.Decode(defaultConfiguration, conf)
 		if err != nil {
 			logger.Fatal(
@@ -71,15 +90,141 @@ func main() {
 				),
 			)
 		}
+
+		parseEnvironmentVariables(conf)
+	}
+
+	if args["audit"].(bool) {
+		err = printAuditLog(conf, args)
+		if err != nil {
+			logger.Fatal(destiny.Format(err, "can't query audit log"))
+		}
+
+		return
+	}
+
+	err = transport.Setup(conf.HTTP)
+	if err != nil {
+		logger.Fatal(destiny.Format(err, "can't setup HTTP client"))
+	}
+
+	state, err := newServiceState(conf, nil)
+	if err != nil {
+		logger.Fatal(destiny.Reason(err))
+	}
+
+	auditLog, err := newAuditLog(conf.Audit.Path)
+	if err != nil {
+		logger.Fatal(destiny.Reason(err))
 	}
 
-	parseEnvironmentVariables(conf)
+	eventStore, err := openEventStore(conf.Events.StateFile)
+	if err != nil {
+		logger.Fatal(destiny.Reason(err))
+	}
 
-	actionService := newActionACKService(
-		conf,
+	actionService, err := newActionACKService(
+		configFile,
+		state,
 		logger,
-		definedMessenger,
+		auditLog,
+		eventStore,
 	)
+	if err != nil {
+		logger.Fatal(destiny.Reason(err))
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(
+			signals,
+			syscall.SIGINT,
+			syscall.SIGTERM,
+			syscall.SIGHUP,
+		)
+
+		for received := range signals {
+			if received == syscall.SIGHUP {
+				err := actionService.reload()
+				if err != nil {
+					logger.Error(
+						karma.Format(
+							err,
+							"config is rejected, current one is kept",
+						),
+					)
+				}
+
+				continue
+			}
+
+			logger.Infof("received %s, shutting down", received)
+
+			actionService.shutdown()
+
+			return
+		}
+	}()
+
+	err = actionService.run()
+	if err != nil {
+		logger.Fatal(destiny.Format(err, "can't serve HTTP"))
+	}
+}
+
+// printAuditLog - print records of audit log which match
+// passed arguments as JSONL
+func printAuditLog(conf *config, args map[string]interface{}) error {
+	if conf.Audit.Path == "" {
+		return errors.New("audit log path is not configured")
+	}
+
+	filter := auditFilter{}
+
+	if value, ok := args["--event"].(string); ok {
+		filter.EventID = value
+	}
+
+	if value, ok := args["--user"].(string); ok {
+		filter.User = value
+	}
+
+	if value, ok := args["--channel"].(string); ok {
+		filter.Channel = value
+	}
+
+	now := time.Now()
+
+	if value, ok := args["--since"].(string); ok {
+		since, err := parseAuditTime(value, now)
+		if err != nil {
+			return karma.Format(err, "invalid --since")
+		}
+
+		filter.Since = since
+	}
+
+	if value, ok := args["--until"].(string); ok {
+		until, err := parseAuditTime(value, now)
+		if err != nil {
+			return karma.Format(err, "invalid --until")
+		}
+
+		filter.Until = until
+	}
+
+	records, err := queryAuditLog(conf.Audit.Path, filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
 
-	actionService.run()
+	return nil
 }
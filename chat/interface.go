@@ -1,14 +1,39 @@
 package chat
 
+import "context"
+
 // Message - interface which must be implemets by all
 // chats requests representation
 type Message interface {
 	SetChannel(name string)
 	SetUsername(name string)
 	SetIcon(icon string)
+	SetText(text string)
 	CreateAttachment(text string, color string) MessageAttachment
 	GetAttachment(attachmentID int) (MessageAttachment, error)
-	SendRequest(url string, token string) error
+	SendRequest(ctx context.Context, url string, token string) (PostRef, error)
+	Update(ctx context.Context, url string, token string, ref PostRef) error
+	Delete(ctx context.Context, url string, token string, ref PostRef) error
+	Reply(
+		ctx context.Context,
+		url string,
+		token string,
+		ref PostRef,
+	) (PostRef, error)
+}
+
+// PostRef - reference to the message which was posted to chat,
+// it allows to update, delete or reply to the message later.
+// Empty reference is returned when chat doesn't tell where the
+// message was posted, e.g. for incoming webhooks.
+type PostRef struct {
+	Channel string `json:"channel"`
+	ID      string `json:"id"`
+}
+
+// IsEmpty - reports that reference doesn't point to any message
+func (ref PostRef) IsEmpty() bool {
+	return ref.ID == ""
 }
 
 type MessageAttachment interface {
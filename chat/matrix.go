@@ -0,0 +1,635 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zarplata/chattix/transport"
+)
+
+const (
+	// MatrixChattixContentKey - key of custom event content which keeps
+	// action values of chattix message keyed by reaction
+	MatrixChattixContentKey = "io.github.zarplata.chattix"
+
+	matrixMessageType = "m.text"
+	matrixHTMLFormat  = "org.matrix.custom.html"
+	matrixAPIPrefix   = "/_matrix/client/v3"
+)
+
+// MatrixMessage - represents Matrix m.room.message event
+type MatrixMessage struct {
+	RoomID string
+	// Text - text above attachments, e.g. with mentions
+	Text        string
+	Username    string
+	IconURL     string
+	Replaces    string
+	ReplyTo     string
+	Attachments []*MatrixAttachment
+}
+
+// MatrixAttachment - represents a part of Matrix message which
+// rendered like Slack or Mattermost attachment
+type MatrixAttachment struct {
+	Color      string
+	Title      string
+	Text       string
+	AuthorName string
+	AuthorIcon string
+	Fields     []*MatrixAttachmentField
+	Actions    []*MatrixAction
+}
+
+// MatrixAttachmentField - represents a field for Matrix attachment
+type MatrixAttachmentField struct {
+	Title string
+	Value interface{}
+	Short bool
+}
+
+// MatrixAction - represents an action for Matrix attachment.
+// Matrix has no buttons, so the action is performed by a reaction
+// with Text as reaction key.
+type MatrixAction struct {
+	Name  string
+	Text  string
+	Type  string
+	Value interface{}
+}
+
+// MatrixMessageContent - content of m.room.message event
+type MatrixMessageContent struct {
+	MessageType   string                `json:"msgtype"`
+	Body          string                `json:"body"`
+	Format        string                `json:"format,omitempty"`
+	FormattedBody string                `json:"formatted_body,omitempty"`
+	Chattix       interface{}           `json:"io.github.zarplata.chattix,omitempty"`
+	NewContent    *MatrixMessageContent `json:"m.new_content,omitempty"`
+	RelatesTo     *MatrixRelation       `json:"m.relates_to,omitempty"`
+}
+
+// MatrixRelation - represents m.relates_to of Matrix event
+type MatrixRelation struct {
+	RelationType string                `json:"rel_type,omitempty"`
+	EventID      string                `json:"event_id,omitempty"`
+	Key          string                `json:"key,omitempty"`
+	InReplyTo    *MatrixEventReference `json:"m.in_reply_to,omitempty"`
+}
+
+// MatrixEventReference - represents reference to Matrix event
+type MatrixEventReference struct {
+	EventID string `json:"event_id"`
+}
+
+type matrixSendResponse struct {
+	EventID string `json:"event_id"`
+}
+
+type matrixErrorResponse struct {
+	Code  string `json:"errcode"`
+	Error string `json:"error"`
+}
+
+type matrixRoomAliasResponse struct {
+	RoomID string `json:"room_id"`
+}
+
+// NewMatrixMessage - creates a new Matrix message
+func NewMatrixMessage() Message {
+	return &MatrixMessage{}
+}
+
+// SetChannel - set room where message will be posted.
+// Both room ID and room alias are accepted.
+func (request *MatrixMessage) SetChannel(
+	name string,
+) {
+	request.RoomID = name
+}
+
+// SetIcon - set icon URL to message. Matrix message is always
+// displayed with avatar of the bot user, so icon is shown
+// only inside attachment author line.
+func (request *MatrixMessage) SetIcon(
+	icon string,
+) {
+	request.IconURL = icon
+}
+
+// SetText - set text of message which is shown above attachments
+func (request *MatrixMessage) SetText(
+	text string,
+) {
+	request.Text = text
+}
+
+// SetUsername - set username for message. Matrix message is always
+// sent from the bot user, so username is used only for plain
+// text representation.
+func (request *MatrixMessage) SetUsername(
+	name string,
+) {
+	request.Username = name
+}
+
+// Replace - make message an edit (m.replace) of already
+// posted event
+func (request *MatrixMessage) Replace(
+	eventID string,
+) {
+	request.Replaces = eventID
+}
+
+// CreateAttachment - creates attachment to Matrix message
+// with passed text and color
+func (request *MatrixMessage) CreateAttachment(
+	text string, color string,
+) MessageAttachment {
+	attachment := &MatrixAttachment{
+		Color: color,
+		Text:  text,
+	}
+
+	request.Attachments = append(
+		request.Attachments,
+		attachment,
+	)
+
+	return attachment
+}
+
+// GetAttachment - return attachment by index
+// from message
+func (request *MatrixMessage) GetAttachment(
+	attachmentID int,
+) (MessageAttachment, error) {
+	if len(request.Attachments) < attachmentID+1 {
+		return nil, fmt.Errorf(
+			"attachement %d did not found",
+			attachmentID,
+		)
+	}
+
+	return request.Attachments[attachmentID], nil
+}
+
+// Content - build content of m.room.message event
+func (request *MatrixMessage) Content() *MatrixMessageContent {
+	content := &MatrixMessageContent{
+		MessageType:   matrixMessageType,
+		Body:          request.plainText(),
+		Format:        matrixHTMLFormat,
+		FormattedBody: request.html(),
+		Chattix:       request.actionValue(),
+	}
+
+	if request.ReplyTo != "" {
+		content.RelatesTo = &MatrixRelation{
+			InReplyTo: &MatrixEventReference{
+				EventID: request.ReplyTo,
+			},
+		}
+	}
+
+	if request.Replaces == "" {
+		return content
+	}
+
+	return &MatrixMessageContent{
+		MessageType:   matrixMessageType,
+		Body:          "* " + content.Body,
+		Format:        matrixHTMLFormat,
+		FormattedBody: "* " + content.FormattedBody,
+		NewContent:    content,
+		RelatesTo: &MatrixRelation{
+			RelationType: "m.replace",
+			EventID:      request.Replaces,
+		},
+	}
+}
+
+// SendRequest - sending message to Matrix room through
+// client-server API, url is the homeserver URL
+func (request *MatrixMessage) SendRequest(
+	ctx context.Context,
+	url string,
+	token string,
+) (PostRef, error) {
+	roomID, err := resolveMatrixRoom(ctx, url, token, request.RoomID)
+	if err != nil {
+		return PostRef{}, err
+	}
+
+	var answer matrixSendResponse
+
+	err = CallMatrix(
+		ctx,
+		transport.Client(),
+		"PUT",
+		MatrixAPIURL(
+			url,
+			"rooms", roomID, "send", "m.room.message", matrixTransactionID(),
+		),
+		token,
+		request.Content(),
+		&answer,
+	)
+	if err != nil {
+		return PostRef{}, err
+	}
+
+	return PostRef{
+		Channel: roomID,
+		ID:      answer.EventID,
+	}, nil
+}
+
+// Update - edit event which was posted before, the current
+// message becomes the new content of the event
+func (request *MatrixMessage) Update(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) error {
+	update := *request
+	update.RoomID = ref.Channel
+	update.ReplyTo = ""
+	update.Replace(ref.ID)
+
+	_, err := update.SendRequest(ctx, url, token)
+
+	return err
+}
+
+// Delete - redact event which was posted before
+func (request *MatrixMessage) Delete(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) error {
+	return CallMatrix(
+		ctx,
+		transport.Client(),
+		"PUT",
+		MatrixAPIURL(
+			url,
+			"rooms", ref.Channel, "redact", ref.ID, matrixTransactionID(),
+		),
+		token,
+		map[string]string{},
+		nil,
+	)
+}
+
+// Reply - post message as a reply to event which was posted before
+func (request *MatrixMessage) Reply(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) (PostRef, error) {
+	reply := *request
+	reply.RoomID = ref.Channel
+	reply.Replaces = ""
+	reply.ReplyTo = ref.ID
+
+	return reply.SendRequest(ctx, url, token)
+}
+
+// CallMatrix - call method of client-server API and decode its answer
+// into answer if it's not nil, requestURL is built by MatrixAPIURL.
+// Latency of long polling /sync isn't observed because it lasts until
+// timeout when nothing happens.
+func CallMatrix(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	requestURL string,
+	token string,
+	payload interface{},
+	answer interface{},
+) error {
+	observe := true
+	if parsed, err := url.Parse(requestURL); err == nil {
+		observe = !strings.HasSuffix(parsed.Path, "/sync")
+	}
+
+	statusCode, body, err := sendRequestWithClient(
+		ctx,
+		client,
+		backendMatrix,
+		method,
+		requestURL,
+		token,
+		payload,
+		observe,
+	)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		var matrixError matrixErrorResponse
+
+		// homeserver may answer without JSON, e.g. behind proxy
+		_ = json.Unmarshal(body, &matrixError)
+
+		return fmt.Errorf(
+			"chat on %s returned %d status code: %s %s",
+			requestURL,
+			statusCode,
+			matrixError.Code,
+			matrixError.Error,
+		)
+	}
+
+	if answer == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(body, answer)
+	if err != nil {
+		return fmt.Errorf(
+			"can't decode answer of %s: %s",
+			requestURL,
+			err,
+		)
+	}
+
+	return nil
+}
+
+// MatrixAPIURL - build URL of client-server API method,
+// every part of path is escaped
+func MatrixAPIURL(
+	homeserverURL string,
+	parts ...string,
+) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = url.PathEscape(part)
+	}
+
+	return strings.TrimSuffix(homeserverURL, "/") +
+		matrixAPIPrefix + "/" + strings.Join(escaped, "/")
+}
+
+func resolveMatrixRoom(
+	ctx context.Context,
+	homeserverURL string,
+	token string,
+	room string,
+) (string, error) {
+	if !strings.HasPrefix(room, "#") {
+		return room, nil
+	}
+
+	var answer matrixRoomAliasResponse
+
+	// status is checked before the answer is decoded, so error
+	// of homeserver without JSON is shown as is
+	err := CallMatrix(
+		ctx,
+		transport.Client(),
+		"GET",
+		MatrixAPIURL(homeserverURL, "directory", "room", room),
+		token,
+		nil,
+		&answer,
+	)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve room alias %s: %s", room, err)
+	}
+
+	return answer.RoomID, nil
+}
+
+func matrixTransactionID() string {
+	return fmt.Sprintf(
+		"chattix-%d-%s",
+		time.Now().UnixNano(),
+		getRandString(7),
+	)
+}
+
+// actionValue - values of actions keyed by reaction
+// which performs an action
+func (request *MatrixMessage) actionValue() interface{} {
+	values := map[string]interface{}{}
+
+	for _, attachment := range request.Attachments {
+		for _, action := range attachment.Actions {
+			if action.Value != nil {
+				values[action.Text] = action.Value
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values
+}
+
+func (request *MatrixMessage) plainText() string {
+	lines := []string{}
+
+	if request.Text != "" {
+		lines = append(lines, request.Text)
+	}
+
+	for _, attachment := range request.Attachments {
+		if attachment.AuthorName != "" {
+			lines = append(lines, attachment.AuthorName)
+		}
+
+		if attachment.Title != "" {
+			lines = append(lines, attachment.Title)
+		}
+
+		if attachment.Text != "" {
+			lines = append(lines, attachment.Text)
+		}
+
+		for _, field := range attachment.Fields {
+			lines = append(
+				lines,
+				fmt.Sprintf("%s: %v", field.Title, field.Value),
+			)
+		}
+
+		for _, action := range attachment.Actions {
+			lines = append(
+				lines,
+				fmt.Sprintf("React with %s to %s", action.Text, action.Name),
+			)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (request *MatrixMessage) html() string {
+	parts := []string{}
+
+	if request.Text != "" {
+		parts = append(
+			parts,
+			strings.Replace(
+				html.EscapeString(strings.TrimSpace(request.Text)),
+				"\n",
+				"<br>",
+				-1,
+			),
+		)
+	}
+
+	for _, attachment := range request.Attachments {
+		lines := []string{}
+
+		if attachment.AuthorName != "" {
+			lines = append(
+				lines,
+				"<i>"+html.EscapeString(attachment.AuthorName)+"</i>",
+			)
+		}
+
+		if attachment.Title != "" {
+			title := "<b>" + html.EscapeString(attachment.Title) + "</b>"
+			if attachment.Color != "" {
+				title = fmt.Sprintf(
+					`<font color="%[1]s" data-mx-color="%[1]s">%[2]s</font>`,
+					html.EscapeString(attachment.Color),
+					title,
+				)
+			}
+
+			lines = append(lines, title)
+		}
+
+		if attachment.Text != "" {
+			lines = append(
+				lines,
+				strings.Replace(
+					html.EscapeString(strings.TrimSpace(attachment.Text)),
+					"\n",
+					"<br>",
+					-1,
+				),
+			)
+		}
+
+		for _, field := range attachment.Fields {
+			lines = append(
+				lines,
+				fmt.Sprintf(
+					"<b>%s</b>: %s",
+					html.EscapeString(field.Title),
+					html.EscapeString(fmt.Sprint(field.Value)),
+				),
+			)
+		}
+
+		for _, action := range attachment.Actions {
+			lines = append(
+				lines,
+				fmt.Sprintf(
+					"<i>React with %s to %s</i>",
+					html.EscapeString(action.Text),
+					html.EscapeString(action.Name),
+				),
+			)
+		}
+
+		parts = append(parts, strings.Join(lines, "<br>"))
+	}
+
+	return strings.Join(parts, "<br><br>")
+}
+
+// AddAction - add an action to attachment, text is the key of
+// reaction which performs an action and value is kept in
+// the event content
+func (attachment *MatrixAttachment) AddAction(
+	name string,
+	text string,
+	actionType string,
+	value interface{},
+) AttachmentAction {
+	action := &MatrixAction{
+		Name:  name,
+		Text:  text,
+		Type:  actionType,
+		Value: value,
+	}
+
+	attachment.Actions = append(
+		attachment.Actions,
+		action,
+	)
+
+	return action
+}
+
+// SetColor - set attachment color
+func (attachment *MatrixAttachment) SetColor(
+	color string,
+) {
+	attachment.Color = color
+}
+
+// SetText - set attachment text
+func (attachment *MatrixAttachment) SetText(
+	text string,
+) {
+	attachment.Text = text
+}
+
+// SetTitle - set title for attachment
+func (attachment *MatrixAttachment) SetTitle(
+	title string,
+) {
+	attachment.Title = title
+}
+
+// AddField - add field to attachment
+func (attachment *MatrixAttachment) AddField(
+	short bool,
+	title string,
+	value interface{},
+) {
+	field := &MatrixAttachmentField{
+		Title: title,
+		Value: value,
+		Short: short,
+	}
+
+	attachment.Fields = append(
+		attachment.Fields,
+		field,
+	)
+}
+
+// SetText - set reaction key of action
+func (action *MatrixAction) SetText(
+	text string,
+) {
+	action.Text = text
+}
+
+// SetName - set name of action
+func (action *MatrixAction) SetName(
+	name string,
+) {
+	action.Name = name
+}
@@ -1,12 +1,16 @@
 package chat
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"strings"
 )
 
+const mattermostAPIPath = "/api/v4"
+
 // MattermostMessage - represents Mattermost message
 type MattermostMessage struct {
 	Text        string                  `json:"text"`
@@ -17,6 +21,22 @@ type MattermostMessage struct {
 	Attachments []*MattermostAttachment `json:"attachments"`
 }
 
+type mattermostPost struct {
+	ID        string                 `json:"id,omitempty"`
+	ChannelID string                 `json:"channel_id"`
+	RootID    string                 `json:"root_id,omitempty"`
+	Message   string                 `json:"message"`
+	Props     map[string]interface{} `json:"props,omitempty"`
+}
+
+type mattermostChannel struct {
+	ID string `json:"id"`
+}
+
+type mattermostError struct {
+	Message string `json:"message"`
+}
+
 // MattermostAttachment - represents Mattermost message attachment
 type MattermostAttachment struct {
 	ID         int64                        `json:"id"`
@@ -157,6 +177,14 @@ func (request *MattermostMessage) SetIcon(
 	request.IconURL = icon
 }
 
+// SetText - set text of message which is shown above
+// attachments, mentions work only in this text
+func (request *MattermostMessage) SetText(
+	text string,
+) {
+	request.Text = text
+}
+
 // SetUsername - set username for message
 func (request *MattermostMessage) SetUsername(
 	name string,
@@ -197,55 +225,274 @@ func (request *MattermostMessage) GetAttachment(
 	return request.Attachments[attachmentID], nil
 }
 
-// SendRequest - sending request to Mattermost
+// SendRequest - send message to Mattermost. Message is posted through
+// API only if url is Mattermost API URL (.../api/v4) and token is set,
+// channel is either ID of the channel or team/channel name then.
+// Otherwise url is incoming webhook URL, which doesn't tell where the
+// message is posted, so empty reference is returned.
 func (request *MattermostMessage) SendRequest(
-	url string, token string,
-) error {
-	payload, err := json.Marshal(request)
+	ctx context.Context,
+	url string,
+	token string,
+) (PostRef, error) {
+	if token == "" || !IsMattermostAPIURL(url) {
+		return PostRef{}, sendMattermostWebhook(ctx, url, request)
+	}
+
+	channelID, err := resolveMattermostChannel(
+		ctx,
+		url,
+		token,
+		request.ChannelName,
+	)
 	if err != nil {
-		return err
+		return PostRef{}, err
+	}
+
+	return request.post(ctx, url, token, channelID, "")
+}
+
+// Update - replace post which was posted before with the
+// current message, url is Mattermost API URL
+func (request *MattermostMessage) Update(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) error {
+	patch := map[string]interface{}{
+		"message": request.Text,
+		"props":   request.props(),
 	}
 
-	req, err := http.NewRequest(
+	return callMattermost(
+		ctx,
+		"PUT",
+		fmt.Sprintf("%s/posts/%s/patch", url, ref.ID),
+		token,
+		patch,
+		nil,
+	)
+}
+
+// Delete - delete post which was posted before,
+// url is Mattermost API URL
+func (request *MattermostMessage) Delete(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) error {
+	return callMattermost(
+		ctx,
+		"DELETE",
+		fmt.Sprintf("%s/posts/%s", url, ref.ID),
+		token,
+		nil,
+		nil,
+	)
+}
+
+// Reply - post message to the thread of post which was
+// posted before, url is Mattermost API URL
+func (request *MattermostMessage) Reply(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) (PostRef, error) {
+	return request.post(ctx, url, token, ref.Channel, ref.ID)
+}
+
+func (request *MattermostMessage) post(
+	ctx context.Context,
+	url string,
+	token string,
+	channelID string,
+	rootID string,
+) (PostRef, error) {
+	message := &mattermostPost{
+		ChannelID: channelID,
+		RootID:    rootID,
+		Message:   request.Text,
+		Props:     request.props(),
+	}
+
+	post := mattermostPost{}
+
+	err := callMattermost(
+		ctx,
 		"POST",
+		fmt.Sprintf("%s/posts", url),
+		token,
+		message,
+		&post,
+	)
+	if err != nil {
+		return PostRef{}, err
+	}
+
+	if post.ID == "" {
+		return PostRef{}, fmt.Errorf(
+			"chat on %s didn't return created post",
+			url,
+		)
+	}
+
+	return PostRef{
+		Channel: post.ChannelID,
+		ID:      post.ID,
+	}, nil
+}
+
+// props - message properties in format of Mattermost post,
+// where attachments, username and icon are placed into properties
+func (request *MattermostMessage) props() map[string]interface{} {
+	props := map[string]interface{}{}
+	for key, value := range request.Props {
+		props[key] = value
+	}
+
+	props["attachments"] = request.Attachments
+
+	// username and icon of incoming webhooks are kept by API
+	// only as overrides
+	if request.Username != "" {
+		props["override_username"] = request.Username
+	}
+
+	if request.IconURL != "" {
+		props["override_icon_url"] = request.IconURL
+	}
+
+	return props
+}
+
+// callMattermost - call method of Mattermost API and decode its
+// answer into answer if it's not nil
+func callMattermost(
+	ctx context.Context,
+	method string,
+	url string,
+	token string,
+	payload interface{},
+	answer interface{},
+) error {
+	statusCode, body, err := sendRequest(
+		ctx,
+		backendMattermost,
+		method,
 		url,
-		bytes.NewBuffer(payload),
+		token,
+		payload,
 	)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add(
-		"Content-Type",
-		"application/json",
-	)
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated {
+		mattermostError := mattermostError{}
+		_ = json.Unmarshal(body, &mattermostError)
 
-	if len(token) != 0 {
-		req.Header.Add(
-			"Authorization",
-			"Bearer "+token,
+		return fmt.Errorf(
+			"chat on %s returned %d status code: %s",
+			url,
+			statusCode,
+			mattermostError.Message,
 		)
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(req)
+	if answer == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(body, answer)
 	if err != nil {
-		return err
+		return fmt.Errorf(
+			"can't decode answer of %s: %s",
+			url,
+			err,
+		)
 	}
 
-	defer response.Body.Close()
+	return nil
+}
 
-	if response.StatusCode != http.StatusOK {
+// sendMattermostWebhook - post message through incoming webhook,
+// it answers with plain text
+func sendMattermostWebhook(
+	ctx context.Context,
+	url string,
+	payload interface{},
+) error {
+	statusCode, _, err := sendRequest(
+		ctx,
+		backendMattermost,
+		"POST",
+		url,
+		"",
+		payload,
+	)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
 		return fmt.Errorf(
 			"chat on %s returned %d status code",
 			url,
-			response.StatusCode,
+			statusCode,
 		)
 	}
 
 	return nil
 }
 
+// IsMattermostAPIURL - whether url is base URL of Mattermost API,
+// otherwise it's incoming webhook URL which accepts channel by name
+func IsMattermostAPIURL(url string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(url, "/"), mattermostAPIPath)
+}
+
+// resolveMattermostChannel - ID of channel which is passed either
+// by ID or by team/channel name, API accepts only IDs
+func resolveMattermostChannel(
+	ctx context.Context,
+	url string,
+	token string,
+	channel string,
+) (string, error) {
+	parts := strings.SplitN(channel, "/", 2)
+	if len(parts) != 2 {
+		return channel, nil
+	}
+
+	answer := mattermostChannel{}
+
+	err := callMattermost(
+		ctx,
+		"GET",
+		fmt.Sprintf(
+			"%s/teams/name/%s/channels/name/%s",
+			url,
+			neturl.PathEscape(parts[0]),
+			neturl.PathEscape(strings.TrimPrefix(parts[1], "~")),
+		),
+		token,
+		nil,
+		&answer,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if answer.ID == "" {
+		return "", fmt.Errorf("channel %s is not found", channel)
+	}
+
+	return answer.ID, nil
+}
+
 func (attachment *MattermostAttachment) createAction(
 	name string,
 	actionURL string,
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/zarplata/chattix/metrics"
+	"github.com/zarplata/chattix/transport"
+)
+
+const (
+	backendSlack      = "slack"
+	backendMattermost = "mattermost"
+	backendMatrix     = "matrix"
+)
+
+// RequestDuration - latency of requests to chat APIs by backend and
+// result, chattixd observes its own requests to chats with it too
+var RequestDuration = metrics.NewHistogram(
+	"chattix_chat_request_duration_seconds",
+	"Latency of requests to chat APIs.",
+	metrics.DefaultBuckets,
+	"backend", "result",
+)
+
+// RequestResult - label of request outcome by error
+// and status code of the answer
+func RequestResult(err error, statusCode int) string {
+	if err != nil || statusCode >= http.StatusBadRequest {
+		return "error"
+	}
+
+	return "ok"
+}
+
+// sendRequest - send JSON payload to chat API and return status code
+// and raw body of the answer, payload can be nil for requests
+// without body. Latency is observed with passed backend label.
+func sendRequest(
+	ctx context.Context,
+	backend string,
+	method string,
+	url string,
+	token string,
+	payload interface{},
+) (int, []byte, error) {
+	return sendRequestWithClient(
+		ctx,
+		transport.Client(),
+		backend,
+		method,
+		url,
+		token,
+		payload,
+		true,
+	)
+}
+
+// sendRequestWithClient - the same as sendRequest but with passed
+// HTTP client, latency is not observed if observe is false, e.g. for
+// long polling which lasts until timeout when nothing happens
+func sendRequestWithClient(
+	ctx context.Context,
+	client *http.Client,
+	backend string,
+	method string,
+	url string,
+	token string,
+	payload interface{},
+	observe bool,
+) (int, []byte, error) {
+	var body io.Reader
+
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		body = bytes.NewBuffer(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		method,
+		url,
+		body,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if payload != nil {
+		req.Header.Add(
+			"Content-Type",
+			"application/json",
+		)
+	}
+
+	if len(token) != 0 {
+		req.Header.Add(
+			"Authorization",
+			"Bearer "+token,
+		)
+	}
+
+	start := time.Now()
+
+	response, err := client.Do(req)
+
+	statusCode := 0
+	if response != nil {
+		statusCode = response.StatusCode
+	}
+
+	if observe {
+		RequestDuration.WithLabelValues(
+			backend,
+			RequestResult(err, statusCode),
+		).Observe(time.Since(start).Seconds())
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer response.Body.Close()
+
+	answer, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return response.StatusCode, answer, nil
+}
@@ -1,13 +1,16 @@
 package chat
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strings"
 )
 
+const slackAPIPath = "/api"
+
 // SlackMessage - represents Slack message
 type SlackMessage struct {
 	Text        string             `json:"text"`
@@ -15,9 +18,18 @@ type SlackMessage struct {
 	IconURL     string             `json:"icon_url"`
 	ChannelName string             `json:"channel"`
 	AsUser      bool               `json:"as_user"`
+	TS          string             `json:"ts,omitempty"`
+	ThreadTS    string             `json:"thread_ts,omitempty"`
 	Attachments []*SlackAttachment `json:"attachments"`
 }
 
+type slackAPIResponse struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
 // SlackAttachment - represents an attachment in Slack message`
 type SlackAttachment struct {
 	ID         int64                   `json:"id"`
@@ -155,6 +167,14 @@ func (request *SlackMessage) SetIcon(
 	request.IconURL = icon
 }
 
+// SetText - set text of message which is shown above
+// attachments, mentions work only in this text
+func (request *SlackMessage) SetText(
+	text string,
+) {
+	request.Text = text
+}
+
 // SetUsername - set username which will post a messages.
 // For Slack it will be random name because Slack glue
 // messages which posted from one username and doesn't
@@ -169,55 +189,231 @@ func (request *SlackMessage) SetUsername(
 	)
 }
 
-// SendRequest - send message to Slack
+// SendRequest - send message to Slack. The url is either Slack API
+// URL, URL of chat.postMessage method or incoming webhook URL. Incoming
+// webhooks don't tell where the message is posted, so empty reference
+// is returned for them.
 func (request *SlackMessage) SendRequest(
-	url string, token string,
+	ctx context.Context,
+	url string,
+	token string,
+) (PostRef, error) {
+	if !isSlackWebAPIURL(url) {
+		return PostRef{}, sendSlackWebhook(ctx, url, request)
+	}
+
+	return request.post(ctx, url, token, "")
+}
+
+// Update - replace message which was posted before
+// with the current message
+func (request *SlackMessage) Update(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
 ) error {
-	payload, err := json.Marshal(request)
+	update := *request
+	update.ChannelName = ref.Channel
+	update.TS = ref.ID
+	update.ThreadTS = ""
+
+	methodURL, err := slackMethodURL(url, "chat.update")
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(
-		"POST",
-		url,
-		bytes.NewBuffer(payload),
+	_, err = callSlack(
+		ctx,
+		methodURL,
+		token,
+		&update,
 	)
+
+	return err
+}
+
+// Delete - delete message which was posted before
+func (request *SlackMessage) Delete(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) error {
+	methodURL, err := slackMethodURL(url, "chat.delete")
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add(
-		"Content-Type",
-		"application/json",
+	_, err = callSlack(
+		ctx,
+		methodURL,
+		token,
+		map[string]string{
+			"channel": ref.Channel,
+			"ts":      ref.ID,
+		},
 	)
 
-	if len(token) != 0 {
-		req.Header.Add(
-			"Authorization",
-			"Bearer "+token,
+	return err
+}
+
+// Reply - post message to the thread of message
+// which was posted before
+func (request *SlackMessage) Reply(
+	ctx context.Context,
+	url string,
+	token string,
+	ref PostRef,
+) (PostRef, error) {
+	reply := *request
+	reply.ChannelName = ref.Channel
+
+	return reply.post(ctx, url, token, ref.ID)
+}
+
+func (request *SlackMessage) post(
+	ctx context.Context,
+	url string,
+	token string,
+	threadTS string,
+) (PostRef, error) {
+	message := *request
+	message.TS = ""
+	message.ThreadTS = threadTS
+
+	methodURL, err := slackMethodURL(url, "chat.postMessage")
+	if err != nil {
+		return PostRef{}, err
+	}
+
+	answer, err := callSlack(
+		ctx,
+		methodURL,
+		token,
+		&message,
+	)
+	if err != nil {
+		return PostRef{}, err
+	}
+
+	return PostRef{
+		Channel: answer.Channel,
+		ID:      answer.TS,
+	}, nil
+}
+
+// callSlack - call method of Slack Web API
+func callSlack(
+	ctx context.Context,
+	url string,
+	token string,
+	payload interface{},
+) (*slackAPIResponse, error) {
+	statusCode, body, err := sendRequest(
+		ctx,
+		backendSlack,
+		"POST",
+		url,
+		token,
+		payload,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"chat on %s returned %d status code",
+			url,
+			statusCode,
 		)
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(req)
+	answer := &slackAPIResponse{}
+
+	err = json.Unmarshal(body, answer)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf(
+			"can't decode answer of %s: %s",
+			url,
+			err,
+		)
 	}
 
-	defer response.Body.Close()
+	if !answer.Ok {
+		return nil, fmt.Errorf(
+			"chat on %s returned error: %s",
+			url,
+			answer.Error,
+		)
+	}
+
+	return answer, nil
+}
+
+// sendSlackWebhook - post message through incoming webhook, it
+// answers with plain text
+func sendSlackWebhook(
+	ctx context.Context,
+	url string,
+	payload interface{},
+) error {
+	statusCode, _, err := sendRequest(
+		ctx,
+		backendSlack,
+		"POST",
+		url,
+		"",
+		payload,
+	)
+	if err != nil {
+		return err
+	}
 
-	if response.StatusCode != http.StatusOK {
+	if statusCode != http.StatusOK {
 		return fmt.Errorf(
 			"chat on %s returned %d status code",
 			url,
-			response.StatusCode,
+			statusCode,
 		)
 	}
 
 	return nil
 }
 
+// isSlackWebAPIURL - whether url is Slack API URL or URL of any
+// method in it, otherwise it's incoming webhook URL
+func isSlackWebAPIURL(url string) bool {
+	url = strings.TrimSuffix(url, "/")
+
+	return strings.HasSuffix(url, slackAPIPath) ||
+		strings.Contains(url, slackAPIPath+"/")
+}
+
+// slackMethodURL - build URL of Slack Web API method, url is
+// either Slack API URL or URL of any method in it. Incoming
+// webhooks can only post messages, so their URLs are rejected.
+func slackMethodURL(url string, method string) (string, error) {
+	if !isSlackWebAPIURL(url) {
+		return "", fmt.Errorf(
+			"%s is not Slack Web API URL, %s can't be called",
+			url,
+			method,
+		)
+	}
+
+	url = strings.TrimSuffix(url, "/")
+
+	if strings.HasSuffix(url, slackAPIPath) {
+		return url + "/" + method, nil
+	}
+
+	index := strings.LastIndex(url, slackAPIPath+"/")
+
+	return url[:index] + slackAPIPath + "/" + method, nil
+}
+
 // CreateAttachment - create new message attachment and append it
 func (request *SlackMessage) CreateAttachment(
 	text string, color string,
@@ -1,5 +1,25 @@
 package context
 
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature - context was changed after signing
+	// or signed with another secret
+	ErrInvalidSignature = errors.New("invalid action context signature")
+
+	// ErrExpired - context was signed too long ago
+	ErrExpired = errors.New("action context is expired")
+)
+
 // ContextActionACK - context for Mattermost action
 type ContextActionACK struct {
 	EventID  string
@@ -9,4 +29,119 @@ type ContextActionACK struct {
 	Channel  string
 	Username string
 	IconURL  string
+	// ZabbixAction - bitmask of event.acknowledge action,
+	// zero means default acknowledgement
+	ZabbixAction int
+	// ZabbixSeverity - new severity of event if action changes it
+	ZabbixSeverity string
+	// ZabbixMessage - message for Zabbix instead of author message
+	ZabbixMessage string
+	Expires       int64
+	Signature     string
+}
+
+// SlackValue - encode context as value of Slack button. Slack keeps
+// the original message itself, so the message is not encoded, and
+// plain event ID is used for default acknowledgement.
+func (context *ContextActionACK) SlackValue() (string, error) {
+	if context.ZabbixAction == 0 &&
+		context.ZabbixSeverity == "" &&
+		context.ZabbixMessage == "" {
+		return context.EventID, nil
+	}
+
+	value := *context
+	value.Severity = ""
+	value.Message = ""
+	value.Channel = ""
+	value.Username = ""
+	value.IconURL = ""
+	value.Expires = 0
+	value.Signature = ""
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+// ParseSlackValue - decode context from value of Slack button,
+// name of the button is used as action name
+func ParseSlackValue(
+	name string,
+	value string,
+) (*ContextActionACK, error) {
+	context := &ContextActionACK{}
+
+	if strings.HasPrefix(value, "{") {
+		err := json.Unmarshal([]byte(value), context)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		context.EventID = value
+	}
+
+	if context.Action == "" {
+		context.Action = name
+	}
+
+	return context, nil
+}
+
+// Sign - sign context with shared secret, signed context
+// is valid till expiration time
+func (context *ContextActionACK) Sign(
+	secret string,
+	expires time.Time,
+) {
+	context.Expires = expires.Unix()
+	context.Signature = context.sign(secret)
+}
+
+// Verify - check that context is signed with shared
+// secret and is not expired yet
+func (context *ContextActionACK) Verify(
+	secret string,
+	now time.Time,
+) error {
+	expected := context.sign(secret)
+
+	if !hmac.Equal([]byte(expected), []byte(context.Signature)) {
+		return ErrInvalidSignature
+	}
+
+	if now.Unix() > context.Expires {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func (context *ContextActionACK) sign(secret string) string {
+	// every field is signed, so nothing can be changed
+	// by the one who calls the action
+	payload := strings.Join(
+		[]string{
+			context.EventID,
+			context.Action,
+			context.Severity,
+			context.Message,
+			context.Channel,
+			context.Username,
+			context.IconURL,
+			strconv.Itoa(context.ZabbixAction),
+			context.ZabbixSeverity,
+			context.ZabbixMessage,
+			strconv.FormatInt(context.Expires, 10),
+		},
+		"\x00",
+	)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,116 @@
+package context
+
+import (
+	"testing"
+	"time"
+)
+
+func newSignedContext(secret string, expires time.Time) *ContextActionACK {
+	context := &ContextActionACK{
+		EventID:        "42",
+		Action:         "ACK",
+		Severity:       "PROBLEM",
+		Message:        "disk is full",
+		Channel:        "#alerts",
+		Username:       "zabbix",
+		IconURL:        "http://localhost/icon",
+		ZabbixAction:   6,
+		ZabbixSeverity: "4",
+		ZabbixMessage:  "Acknowledged by {{USERNAME}}",
+	}
+
+	context.Sign(secret, expires)
+
+	return context
+}
+
+func TestContextActionACK_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		secret string
+		now    time.Time
+		tamper func(context *ContextActionACK)
+		err    error
+	}{
+		{
+			name:   "valid",
+			secret: "secret",
+			now:    now,
+		},
+		{
+			name:   "wrong secret",
+			secret: "another secret",
+			now:    now,
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "expired",
+			secret: "secret",
+			now:    expires.Add(time.Second),
+			err:    ErrExpired,
+		},
+		{
+			name:   "tampered event",
+			secret: "secret",
+			now:    now,
+			tamper: func(context *ContextActionACK) {
+				context.EventID = "43"
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "tampered action",
+			secret: "secret",
+			now:    now,
+			tamper: func(context *ContextActionACK) {
+				context.ZabbixAction |= 1
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "tampered message",
+			secret: "secret",
+			now:    now,
+			tamper: func(context *ContextActionACK) {
+				context.ZabbixMessage = "Closed by {{USERNAME}}"
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "extended expiration",
+			secret: "secret",
+			now:    expires.Add(time.Second),
+			tamper: func(context *ContextActionACK) {
+				context.Expires = expires.Add(time.Hour).Unix()
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "removed signature",
+			secret: "secret",
+			now:    now,
+			tamper: func(context *ContextActionACK) {
+				context.Signature = ""
+			},
+			err: ErrInvalidSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context := newSignedContext("secret", expires)
+
+			if test.tamper != nil {
+				test.tamper(context)
+			}
+
+			err := context.Verify(test.secret, test.now)
+			if err != test.err {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
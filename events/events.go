@@ -0,0 +1,94 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+)
+
+// Path - route of chattixd where webhook registers posted alerts
+const Path = "/events"
+
+// Registration - alert which is posted to chat by webhook, chattixd
+// tracks its event until it's acknowledged or resolved
+type Registration struct {
+	EventID   string `json:"event_id"`
+	Messenger string `json:"messenger"`
+	Channel   string `json:"channel"`
+	// Ref - posted message, it's empty if chat doesn't tell where
+	// the message was posted, e.g. for incoming webhooks
+	Ref     chat.PostRef `json:"ref"`
+	Message string       `json:"message"`
+	// Post - message as it was posted in format of the chat, post is
+	// rebuilt from it when it's synced with Zabbix
+	Post json.RawMessage `json:"post,omitempty"`
+}
+
+// Register - pass registration to chattixd available on url,
+// token is the bearer token of its events route
+func Register(
+	ctx context.Context,
+	url string,
+	token string,
+	registration Registration,
+) error {
+	destiny := karma.Describe(
+		"method", "Register",
+	).Describe(
+		"url", secrets.Redact(url),
+	).Describe(
+		"eventID", registration.EventID,
+	)
+
+	body := new(bytes.Buffer)
+
+	err := json.NewEncoder(body).Encode(registration)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't encode registration")
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		strings.TrimSuffix(url, "/")+Path,
+		body,
+	)
+	if err != nil {
+		return destiny.Reason(err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	response, err := transport.Client().Do(request)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason("can't execute HTTP request")
+	}
+
+	defer response.Body.Close()
+
+	// connection is reused only if body is read
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return destiny.Describe(
+			"status code", response.StatusCode,
+		).Reason("chattixd rejected the registration")
+	}
+
+	return nil
+}
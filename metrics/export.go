@@ -0,0 +1,256 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+)
+
+// labelsSeparator - separator of label pairs in key of series,
+// it can't be met in valid UTF-8 label value
+const labelsSeparator = "\xff"
+
+// WriteFile - write metrics of registry to file for textfile collector
+// of node_exporter
+func WriteFile(path string) error {
+	return writeFile(path, Registry)
+}
+
+// writeFile - write metrics to file for textfile collector of
+// node_exporter. Short-lived processes export into the same file, so
+// counters and histograms are added to the values which are already
+// written. File is locked while it's updated and replaced atomically,
+// so collector never reads partially written file.
+func writeFile(path string, gatherer prometheus.Gatherer) error {
+	destiny := karma.Describe(
+		"path", path,
+	)
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return destiny.Format(err, "can't open lock of metrics file")
+	}
+
+	defer lock.Close()
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return destiny.Format(err, "can't lock metrics file")
+	}
+
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return destiny.Format(err, "can't gather metrics")
+	}
+
+	previous, err := os.Open(path)
+	switch {
+	case err == nil:
+		written, err := readFamilies(previous)
+		previous.Close()
+		if err != nil {
+			return destiny.Format(err, "can't parse metrics file")
+		}
+
+		families = mergeFamilies(families, written)
+
+	case !os.IsNotExist(err):
+		return destiny.Format(err, "can't read metrics file")
+	}
+
+	temporary, err := os.CreateTemp(
+		filepath.Dir(path),
+		"."+filepath.Base(path)+".",
+	)
+	if err != nil {
+		return destiny.Format(err, "can't create metrics file")
+	}
+
+	defer os.Remove(temporary.Name())
+
+	err = writeFamilies(temporary, families)
+	if err != nil {
+		temporary.Close()
+		return destiny.Format(err, "can't write metrics file")
+	}
+
+	err = temporary.Chmod(0644)
+	if err != nil {
+		temporary.Close()
+		return destiny.Format(err, "can't write metrics file")
+	}
+
+	err = temporary.Close()
+	if err != nil {
+		return destiny.Format(err, "can't write metrics file")
+	}
+
+	err = os.Rename(temporary.Name(), path)
+	if err != nil {
+		return destiny.Format(err, "can't replace metrics file")
+	}
+
+	return nil
+}
+
+func writeFamilies(writer io.Writer, families []*dto.MetricFamily) error {
+	for _, family := range families {
+		_, err := expfmt.MetricFamilyToText(writer, family)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readFamilies(reader io.Reader) ([]*dto.MetricFamily, error) {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	index, err := parser.TextToMetricFamilies(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	families := []*dto.MetricFamily{}
+	for _, family := range index {
+		families = append(families, family)
+	}
+
+	return families, nil
+}
+
+// mergeFamilies - add values of counters and histograms which are
+// written by previous runs to current ones, gauges keep current values.
+// Series and families which are not exported now are kept as they were
+// written, families which changed their type are replaced.
+func mergeFamilies(
+	current []*dto.MetricFamily,
+	previous []*dto.MetricFamily,
+) []*dto.MetricFamily {
+	index := map[string]*dto.MetricFamily{}
+	for _, family := range current {
+		index[family.GetName()] = family
+	}
+
+	for _, written := range previous {
+		family, ok := index[written.GetName()]
+		if !ok {
+			current = append(current, written)
+			continue
+		}
+
+		if family.GetType() != written.GetType() {
+			continue
+		}
+
+		series := map[string]*dto.Metric{}
+		for _, metric := range family.Metric {
+			series[seriesKey(metric)] = metric
+		}
+
+		for _, metric := range written.Metric {
+			found, ok := series[seriesKey(metric)]
+			if !ok {
+				family.Metric = append(family.Metric, metric)
+				continue
+			}
+
+			accumulate(family.GetType(), found, metric)
+		}
+
+		sort.Slice(family.Metric, func(i, j int) bool {
+			return seriesKey(family.Metric[i]) < seriesKey(family.Metric[j])
+		})
+	}
+
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].GetName() < current[j].GetName()
+	})
+
+	return current
+}
+
+// accumulate - add value of previous series to the current one
+func accumulate(kind dto.MetricType, metric *dto.Metric, previous *dto.Metric) {
+	switch kind {
+	case dto.MetricType_COUNTER:
+		value := metric.GetCounter().GetValue() +
+			previous.GetCounter().GetValue()
+
+		metric.Counter.Value = &value
+
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+
+		count := histogram.GetSampleCount() +
+			previous.GetHistogram().GetSampleCount()
+		sum := histogram.GetSampleSum() +
+			previous.GetHistogram().GetSampleSum()
+
+		histogram.SampleCount = &count
+		histogram.SampleSum = &sum
+
+		// +Inf bucket is written from count of samples, buckets which
+		// aren't observed now can't be merged consistently
+		for _, written := range previous.GetHistogram().GetBucket() {
+			if math.IsInf(written.GetUpperBound(), 1) {
+				continue
+			}
+
+			for _, bucket := range histogram.Bucket {
+				if bucket.GetUpperBound() == written.GetUpperBound() {
+					cumulative := bucket.GetCumulativeCount() +
+						written.GetCumulativeCount()
+
+					bucket.CumulativeCount = &cumulative
+				}
+			}
+		}
+	}
+}
+
+// seriesKey - label pairs of series which identify it in family
+func seriesKey(metric *dto.Metric) string {
+	pairs := []string{}
+	for _, label := range metric.GetLabel() {
+		pairs = append(pairs, label.GetName()+"="+label.GetValue())
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, labelsSeparator)
+}
+
+// Push - replace metrics of job in Prometheus Pushgateway
+// with metrics of registry
+func Push(ctx context.Context, gatewayURL string, job string) error {
+	err := push.New(gatewayURL, job).
+		Gatherer(Registry).
+		Client(transport.Client()).
+		PushContext(ctx)
+	if err != nil {
+		// answer of pushgateway contains the full URL
+		return karma.Describe(
+			"url", secrets.Redact(gatewayURL),
+		).Format(secrets.Redact(err.Error()), "can't push metrics")
+	}
+
+	return nil
+}
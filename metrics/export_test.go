@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestRegistry() (
+	*prometheus.Registry,
+	*prometheus.CounterVec,
+	*prometheus.GaugeVec,
+	*prometheus.HistogramVec,
+) {
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "test_requests_total",
+			Help: "Requests by channel.",
+		},
+		[]string{"channel"},
+	)
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "test_last_delivery",
+			Help: "Last delivery.",
+		},
+		[]string{"channel"},
+	)
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "test_duration_seconds",
+			Help:    "Duration.",
+			Buckets: []float64{0.1, 1},
+		},
+		[]string{"backend"},
+	)
+
+	registry.MustRegister(counter, gauge, histogram)
+
+	return registry, counter, gauge, histogram
+}
+
+func write(t *testing.T, families []*dto.MetricFamily) string {
+	buffer := new(bytes.Buffer)
+
+	err := writeFamilies(buffer, families)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+func TestReadFamilies_RejectsInvalidValue(t *testing.T) {
+	_, err := readFamilies(strings.NewReader("test_total{a=\"b\"} many\n"))
+	if err == nil {
+		t.Fatal("invalid value is accepted")
+	}
+}
+
+func TestMergeFamilies(t *testing.T) {
+	previous, err := readFamilies(strings.NewReader(strings.Join(
+		[]string{
+			"# TYPE test_requests_total counter",
+			`test_requests_total{channel="a"} 2`,
+			`test_requests_total{channel="old"} 7`,
+			"# TYPE test_last_delivery gauge",
+			`test_last_delivery{channel="a"} 100`,
+			"# TYPE test_duration_seconds histogram",
+			`test_duration_seconds_bucket{backend="slack",le="0.1"} 1`,
+			`test_duration_seconds_bucket{backend="slack",le="1"} 1`,
+			`test_duration_seconds_bucket{backend="slack",le="+Inf"} 2`,
+			`test_duration_seconds_sum{backend="slack"} 5.05`,
+			`test_duration_seconds_count{backend="slack"} 2`,
+			"# TYPE test_removed_total counter",
+			"test_removed_total 4",
+			"",
+		},
+		"\n",
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, counter, gauge, histogram := newTestRegistry()
+
+	counter.WithLabelValues("a").Inc()
+	gauge.WithLabelValues("a").Set(200)
+	histogram.WithLabelValues("slack").Observe(0.5)
+
+	current, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := write(t, mergeFamilies(current, previous))
+
+	for _, line := range []string{
+		// counters and histograms are accumulated
+		`test_requests_total{channel="a"} 3`,
+		`test_duration_seconds_bucket{backend="slack",le="0.1"} 1`,
+		`test_duration_seconds_bucket{backend="slack",le="1"} 2`,
+		`test_duration_seconds_bucket{backend="slack",le="+Inf"} 3`,
+		`test_duration_seconds_sum{backend="slack"} 5.55`,
+		`test_duration_seconds_count{backend="slack"} 3`,
+		// gauges keep current values
+		`test_last_delivery{channel="a"} 200`,
+		// series and families which are not exported now are kept
+		`test_requests_total{channel="old"} 7`,
+		"test_removed_total 4",
+	} {
+		if !strings.Contains(merged, line+"\n") {
+			t.Errorf("%s is not merged:\n%s", line, merged)
+		}
+	}
+
+	if count := strings.Count(merged, "le=\"+Inf\""); count != 1 {
+		t.Errorf("expected one +Inf bucket, got %d:\n%s", count, merged)
+	}
+}
+
+func TestWriteFile_AccumulatesCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chattix.prom")
+
+	for i := 0; i < 2; i++ {
+		// every run of webhook has its own registry
+		registry, counter, gauge, _ := newTestRegistry()
+
+		counter.WithLabelValues("a").Inc()
+		gauge.WithLabelValues("a").Set(float64(i))
+
+		err := writeFile(path, registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`test_requests_total{channel="a"} 2`,
+		`test_last_delivery{channel="a"} 1`,
+	} {
+		if !strings.Contains(string(contents), line+"\n") {
+			t.Errorf("%s is not written:\n%s", line, contents)
+		}
+	}
+}
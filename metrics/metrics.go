@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets - buckets of histograms of request latency in seconds
+var DefaultBuckets = prometheus.DefBuckets
+
+// Registry - registry of chattix metrics which is served by Handler and
+// exported by WriteFile and Push. It's not the default registry of
+// Prometheus, so runtime metrics of short-lived webhook aren't exported
+// and accumulated with its own metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+// NewCounter - create counter with passed label names
+func NewCounter(
+	name string,
+	help string,
+	labels ...string,
+) *prometheus.CounterVec {
+	return factory.NewCounterVec(
+		prometheus.CounterOpts{Name: name, Help: help},
+		labels,
+	)
+}
+
+// NewGauge - create gauge with passed label names
+func NewGauge(
+	name string,
+	help string,
+	labels ...string,
+) *prometheus.GaugeVec {
+	return factory.NewGaugeVec(
+		prometheus.GaugeOpts{Name: name, Help: help},
+		labels,
+	)
+}
+
+// NewGaugeFunc - create gauge which value is returned by function
+// on every export
+func NewGaugeFunc(
+	name string,
+	help string,
+	function func() float64,
+) prometheus.GaugeFunc {
+	return factory.NewGaugeFunc(
+		prometheus.GaugeOpts{Name: name, Help: help},
+		function,
+	)
+}
+
+// NewHistogram - create histogram with passed upper bounds of buckets
+// and label names, +Inf bucket is added implicitly
+func NewHistogram(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *prometheus.HistogramVec {
+	return factory.NewHistogramVec(
+		prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets},
+		labels,
+	)
+}
+
+// Handler - serve chattix metrics with runtime metrics of the
+// process for Prometheus
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(
+			prometheus.Gatherers{Registry, prometheus.DefaultGatherer},
+			promhttp.HandlerOpts{},
+		),
+	)
+}
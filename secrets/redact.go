@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask - replacement of secrets in logs and responses
+	Mask = "[redacted]"
+
+	// minSecretLength - shorter values are not registered, otherwise
+	// placeholders like "token" would mask ordinary words
+	minSecretLength = 6
+)
+
+// secretFieldSuffixes - values of config fields which names end with
+// these suffixes are secrets, e.g. messenger_api_token or
+// zabbix_password
+var secretFieldSuffixes = []string{"token", "password", "secret"}
+
+// sensitivePatterns - secrets which can be found without knowing
+// their values: bearer tokens, passwords in URLs, paths of incoming
+// webhooks of Slack (/services/...) and Mattermost (/hooks/...),
+// sensitive query parameters and JSON fields, groups around the
+// secret are kept
+var sensitivePatterns = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{
+		regexp.MustCompile(`(?i)(\bbearer\s+)[^\s"',;]+`),
+		"${1}" + Mask,
+	},
+	{
+		regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+(@)`),
+		"${1}" + Mask + "${2}",
+	},
+	{
+		regexp.MustCompile(`(/(?:hooks|services)/)[^\s"'?#]+`),
+		"${1}" + Mask,
+	},
+	{
+		regexp.MustCompile(
+			`(?i)([?&](?:[a-z_]*token|password|passwd|secret|signature|sig|auth|key)=)[^&\s"']+`,
+		),
+		"${1}" + Mask,
+	},
+	{
+		regexp.MustCompile(
+			`(?i)("(?:[a-z_]*token|password|secret|auth)"\s*:\s*")[^"]+(")`,
+		),
+		"${1}" + Mask + "${2}",
+	},
+}
+
+var registry = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// Register - remember values which should never be shown in logs
+// or responses, they are masked by Redact
+func Register(values ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, value := range values {
+		if len(value) < minSecretLength {
+			continue
+		}
+
+		found := false
+		for _, known := range registry.values {
+			if known == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			registry.values = append(registry.values, value)
+		}
+	}
+
+	// longer values are masked first, so a secret which contains
+	// another one isn't partially shown
+	sort.Slice(registry.values, func(i, j int) bool {
+		return len(registry.values[i]) > len(registry.values[j])
+	})
+}
+
+// RegisterConfig - register values of config fields which names end
+// with token, password or secret, config should be already resolved
+func RegisterConfig(config interface{}) {
+	collect(reflect.ValueOf(config), "")
+}
+
+// Redact - mask registered secrets and values which look like
+// secrets in the text
+func Redact(text string) string {
+	registry.RLock()
+	for _, value := range registry.values {
+		text = strings.ReplaceAll(text, value, Mask)
+	}
+	registry.RUnlock()
+
+	for _, sensitive := range sensitivePatterns {
+		text = sensitive.pattern.ReplaceAllString(text, sensitive.replace)
+	}
+
+	return text
+}
+
+// NewWriter - writer which redacts everything written to output,
+// every write is expected to be a whole record as loggers do
+func NewWriter(output io.Writer) io.Writer {
+	return &redactingWriter{output: output}
+}
+
+type redactingWriter struct {
+	output io.Writer
+}
+
+func (writer *redactingWriter) Write(data []byte) (int, error) {
+	_, err := io.WriteString(writer.output, Redact(string(data)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func collect(value reflect.Value, name string) {
+	switch value.Kind() {
+	case reflect.String:
+		for _, suffix := range secretFieldSuffixes {
+			if strings.HasSuffix(strings.ToLower(name), suffix) {
+				Register(value.String())
+				break
+			}
+		}
+
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			collect(value.Elem(), name)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			collect(value.Field(i), fieldName(field))
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			collect(value.Index(i), name)
+		}
+
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			collect(value.MapIndex(key), name)
+		}
+	}
+}
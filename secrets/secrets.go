@@ -0,0 +1,184 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+const (
+	// FilePrefix - value with this prefix is replaced with contents
+	// of the file, e.g. file:/run/secrets/token
+	FilePrefix = "file:"
+
+	// escapedReference - "$${" is written as literal "${"
+	escapedReference = "$${"
+)
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Resolve - replace references in every string of passed config,
+// which should be a pointer to struct. ${NAME} is replaced with
+// environment variable, ${NAME:-default} is replaced with default
+// if variable is not set, then value with file: prefix is replaced
+// with contents of the file without trailing newline. Environment
+// is expanded first, so ${CREDENTIALS_DIRECTORY} of systemd can be
+// used in path of the file.
+func Resolve(config interface{}) error {
+	value := reflect.ValueOf(config)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return karma.Describe(
+			"type", fmt.Sprintf("%T", config),
+		).Reason("config should be a non-nil pointer")
+	}
+
+	return resolve(value.Elem(), "")
+}
+
+// String - resolve references in one value
+func String(value string) (string, error) {
+	value, err := expandEnv(value)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(value, FilePrefix) {
+		return value, nil
+	}
+
+	path := strings.TrimPrefix(value, FilePrefix)
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", karma.Describe(
+			"file", path,
+		).Format(err, "can't read secret file")
+	}
+
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+func expandEnv(value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	parts := strings.Split(value, escapedReference)
+
+	for i, part := range parts {
+		var err error
+
+		parts[i] = envReference.ReplaceAllStringFunc(
+			part,
+			func(reference string) string {
+				match := envReference.FindStringSubmatch(reference)
+
+				variable, ok := os.LookupEnv(match[1])
+				if ok {
+					return variable
+				}
+
+				if match[2] != "" {
+					return match[3]
+				}
+
+				if err == nil {
+					err = karma.Describe(
+						"variable", match[1],
+					).Reason("environment variable is not set")
+				}
+
+				return ""
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(parts, "${"), nil
+}
+
+func resolve(value reflect.Value, path string) error {
+	switch value.Kind() {
+	case reflect.String:
+		resolved, err := String(value.String())
+		if err != nil {
+			return karma.Describe(
+				"path", path,
+			).Format(err, "can't resolve config value")
+		}
+
+		if value.CanSet() {
+			value.SetString(resolved)
+		}
+
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+
+		return resolve(value.Elem(), path)
+
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			err := resolve(value.Field(i), join(path, fieldName(field)))
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			err := resolve(value.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		// values of map are not addressable, so they are
+		// resolved in copies which replace the originals
+		for _, key := range value.MapKeys() {
+			item := reflect.New(value.Type().Elem()).Elem()
+			item.Set(value.MapIndex(key))
+
+			err := resolve(item, join(path, fmt.Sprint(key.Interface())))
+			if err != nil {
+				return err
+			}
+
+			value.SetMapIndex(key, item)
+		}
+	}
+
+	return nil
+}
+
+// fieldName - name of field in config file
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("toml"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
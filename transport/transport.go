@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout             = 30 * time.Second
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+)
+
+// Config - settings of HTTP client which is used for all outbound
+// requests to chats and Zabbix. Durations are written in format
+// of time.ParseDuration, e.g. "10s".
+type Config struct {
+	Timeout             string `toml:"timeout"`
+	DialTimeout         string `toml:"dial_timeout"`
+	TLSHandshakeTimeout string `toml:"tls_handshake_timeout"`
+	IdleConnTimeout     string `toml:"idle_conn_timeout"`
+	MaxIdleConns        int    `toml:"max_idle_conns"`
+	MaxIdleConnsPerHost int    `toml:"max_idle_conns_per_host"`
+	ProxyURL            string `toml:"proxy_url"`
+	CAFile              string `toml:"ca_file"`
+	CertFile            string `toml:"cert_file"`
+	KeyFile             string `toml:"key_file"`
+	InsecureSkipVerify  bool   `toml:"insecure_skip_verify"`
+}
+
+var (
+	sharedClient = defaultSettings().newClient()
+	mutex        sync.RWMutex
+)
+
+// Setup - replace shared HTTP client with the client
+// created from passed config
+func Setup(config Config) error {
+	client, err := NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	sharedClient = client
+	mutex.Unlock()
+
+	return nil
+}
+
+// Client - return shared HTTP client
+func Client() *http.Client {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return sharedClient
+}
+
+// ClientWithTimeout - return shared HTTP client with another
+// timeout, connections pool is still shared. It's useful for
+// long polling requests.
+func ClientWithTimeout(timeout time.Duration) *http.Client {
+	client := *Client()
+	client.Timeout = timeout
+
+	return &client
+}
+
+// settings - parsed config of HTTP client
+type settings struct {
+	timeout             time.Duration
+	dialTimeout         time.Duration
+	tlsHandshakeTimeout time.Duration
+	idleConnTimeout     time.Duration
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	proxy               func(*http.Request) (*url.URL, error)
+	tlsConfig           *tls.Config
+}
+
+// defaultSettings - settings of empty config, they can't fail,
+// so shared client is created before config is read
+func defaultSettings() settings {
+	return settings{
+		timeout:             defaultTimeout,
+		dialTimeout:         defaultDialTimeout,
+		tlsHandshakeTimeout: defaultTLSHandshakeTimeout,
+		idleConnTimeout:     defaultIdleConnTimeout,
+		maxIdleConns:        defaultMaxIdleConns,
+		maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		proxy:               http.ProxyFromEnvironment,
+		tlsConfig:           &tls.Config{},
+	}
+}
+
+func (settings settings) newClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: settings.proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   settings.dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     settings.tlsConfig,
+		TLSHandshakeTimeout: settings.tlsHandshakeTimeout,
+		IdleConnTimeout:     settings.idleConnTimeout,
+		MaxIdleConns:        settings.maxIdleConns,
+		MaxIdleConnsPerHost: settings.maxIdleConnsPerHost,
+		ForceAttemptHTTP2:   true,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   settings.timeout,
+	}
+}
+
+// NewClient - create HTTP client from config, zero values
+// of config are replaced with defaults
+func NewClient(config Config) (*http.Client, error) {
+	timeout, err := parseDuration(
+		"timeout", config.Timeout, defaultTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dialTimeout, err := parseDuration(
+		"dial_timeout", config.DialTimeout, defaultDialTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsHandshakeTimeout, err := parseDuration(
+		"tls_handshake_timeout",
+		config.TLSHandshakeTimeout,
+		defaultTLSHandshakeTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout, err := parseDuration(
+		"idle_conn_timeout", config.IdleConnTimeout, defaultIdleConnTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	maxIdleConns := config.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+
+	maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"can't parse proxy URL %s: %s",
+				config.ProxyURL,
+				err,
+			)
+		}
+
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings{
+		timeout:             timeout,
+		dialTimeout:         dialTimeout,
+		tlsHandshakeTimeout: tlsHandshakeTimeout,
+		idleConnTimeout:     idleConnTimeout,
+		maxIdleConns:        maxIdleConns,
+		maxIdleConnsPerHost: maxIdleConnsPerHost,
+		proxy:               proxy,
+		tlsConfig:           tlsConfig,
+	}.newClient(), nil
+}
+
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"can't read CA file %s: %s",
+				config.CAFile,
+				err,
+			)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf(
+				"CA file %s doesn't contain any certificate",
+				config.CAFile,
+			)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(
+			config.CertFile,
+			config.KeyFile,
+		)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"can't load client certificate %s: %s",
+				config.CertFile,
+				err,
+			)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func parseDuration(
+	name string,
+	value string,
+	defaultValue time.Duration,
+) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse %s: %s", name, err)
+	}
+
+	return duration, nil
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"math/rand"
+	"sort"
 	"time"
+
+	"github.com/zarplata/chattix/transport"
 )
 
 type config struct {
@@ -10,12 +13,26 @@ type config struct {
 	EventIDRegexp string                     `toml:"event_id_regexp"`
 	Severities    map[string]severityConfig  `toml:"severities"`
 	Actions       map[string]actionConfig    `toml:"actions"`
+	HTTP          transport.Config           `toml:"http"`
+	Metrics       metricsConfig              `toml:"metrics"`
+	Chattixd      chattixdConfig             `toml:"chattixd"`
+}
+
+// chattixdConfig - chattixd where posted problems are registered,
+// so it can escalate them, nothing is registered if url is empty
+type chattixdConfig struct {
+	URL string `toml:"url"`
+	// Token - bearer token of events route of chattixd
+	Token string `toml:"token"`
 }
 
 type messengerConfig struct {
 	MessengerAPIURL   string `toml:"messenger_api_url"`
 	MessengerAPIToken string `toml:"messenger_api_token"`
 	MessengerUsername string `toml:"messenger_username"`
+	AckReaction       string `toml:"ack_reaction"`
+	ActionSecret      string `toml:"action_secret"`
+	ActionTTL         string `toml:"action_ttl"`
 }
 
 type severityConfig struct {
@@ -26,6 +43,21 @@ type severityConfig struct {
 type actionConfig struct {
 	ActionName string `toml:"action_name"`
 	ActionURL  string `toml:"action_url"`
+	// ZabbixAction - bitmask of event.acknowledge action: 1 - close,
+	// 2 - acknowledge, 4 - add message, 8 - change severity,
+	// 16 - unacknowledge, 32 - suppress, 64 - unsuppress,
+	// 128 - change rank to cause; 0 is default acknowledgement
+	ZabbixAction int    `toml:"zabbix_action"`
+	Severity     string `toml:"severity"`
+	Message      string `toml:"message"`
+	Reaction     string `toml:"reaction"`
+	Position     int    `toml:"position"`
+}
+
+// webhookAction - action with the name it's defined in config
+type webhookAction struct {
+	actionConfig
+	Name string
 }
 
 func (c *config) getIconURL(
@@ -54,3 +86,64 @@ func (c *config) getColor(
 
 	return ""
 }
+
+func (c messengerConfig) getAckReaction() string {
+	if c.AckReaction != "" {
+		return c.AckReaction
+	}
+
+	return defaultMatrixAckReaction
+}
+
+func (c messengerConfig) getActionTTL() (time.Duration, error) {
+	if c.ActionTTL == "" {
+		return defaultActionTTL, nil
+	}
+
+	return time.ParseDuration(c.ActionTTL)
+}
+
+// getActions - return actions ordered by position, default ACK
+// action is returned if nothing is defined
+func (c *config) getActions() []webhookAction {
+	if len(c.Actions) == 0 {
+		return []webhookAction{
+			{
+				Name: defaultAction,
+				actionConfig: actionConfig{
+					ActionName: defaultAction,
+				},
+			},
+		}
+	}
+
+	actions := []webhookAction{}
+
+	for name, action := range c.Actions {
+		if action.ActionName == "" {
+			action.ActionName = name
+		}
+
+		if action.ActionURL == "" {
+			action.ActionURL = c.Actions[defaultAction].ActionURL
+		}
+
+		actions = append(
+			actions,
+			webhookAction{
+				Name:         name,
+				actionConfig: action,
+			},
+		)
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Position != actions[j].Position {
+			return actions[i].Position < actions[j].Position
+		}
+
+		return actions[i].Name < actions[j].Name
+	})
+
+	return actions
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	stdcontext "context"
+	"encoding/json"
+
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/events"
+)
+
+// registerEvent - register posted problem in chattixd, so it's
+// escalated if nobody acknowledges it, the alert is already
+// delivered, so error is only logged
+func registerEvent(
+	ctx stdcontext.Context,
+	config chattixdConfig,
+	registration events.Registration,
+) {
+	if config.URL == "" {
+		return
+	}
+
+	err := events.Register(ctx, config.URL, config.Token, registration)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// encodePost - posted message for registration, so chattixd keeps
+// fields and buttons of the alert when it updates the post, it's
+// registered without the message if it can't be encoded
+func encodePost(message chat.Message) json.RawMessage {
+	post, err := json.Marshal(message)
+	if err != nil {
+		logger.Warning(karma.Format(err, "can't encode posted message"))
+		return nil
+	}
+
+	return post
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	stdcontext "context"
+	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
 	"github.com/fatih/structs"
@@ -11,6 +14,9 @@ import (
 	karma "github.com/reconquest/karma-go"
 	chat "github.com/zarplata/chattix/chat"
 	"github.com/zarplata/chattix/context"
+	"github.com/zarplata/chattix/events"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
 )
 
 const (
@@ -20,6 +26,10 @@ const (
 
 	messengerMattermost = "mattermost"
 	messengerSlack      = "slack"
+	messengerMatrix     = "matrix"
+
+	defaultMatrixAckReaction = "✅"
+	defaultActionTTL         = 7 * 24 * time.Hour
 )
 
 var (
@@ -46,6 +56,7 @@ func main() {
 	chatChooser := map[string]func() chat.Message{
 		messengerMattermost: chat.NewMattermostMessage,
 		messengerSlack:      chat.NewSlackMessage,
+		messengerMatrix:     chat.NewMatrixMessage,
 	}
 
 	destiny := karma.Describe(
@@ -57,6 +68,7 @@ func main() {
 	)
 
 	logger = lorg.NewLog()
+	logger.SetOutput(secrets.NewWriter(os.Stderr))
 	conf := &config{}
 
 	_, err := toml.DecodeFile(configPath, conf)
@@ -70,6 +82,26 @@ func main() {
 		)
 	}
 
+	// tokens can be kept in environment and in files of
+	// Docker secrets or systemd credentials
+	err = secrets.Resolve(conf)
+	if err != nil {
+		logger.Fatal(
+			destiny.Format(
+				err,
+				"can't resolve config file %s",
+				configPath,
+			),
+		)
+	}
+
+	secrets.RegisterConfig(conf)
+
+	err = transport.Setup(conf.HTTP)
+	if err != nil {
+		logger.Fatal(destiny.Format(err, "can't setup HTTP client"))
+	}
+
 	eventIDPattern, err := regexp.Compile(conf.EventIDRegexp)
 	if err != nil {
 		logger.Fatal(destiny.Reason(err))
@@ -103,6 +135,8 @@ func main() {
 		eventIDExists = true
 	}
 
+	ctx := stdcontext.Background()
+
 	request := chatChooser[definedMessenger]()
 
 	icon := conf.getIconURL(severity)
@@ -123,11 +157,16 @@ func main() {
 	}
 
 	if severity != severityProblem {
-		err = request.SendRequest(
+		_, err = sendMessage(
+			ctx,
+			request,
 			conf.Messengers[definedMessenger].MessengerAPIURL,
 			conf.Messengers[definedMessenger].MessengerAPIToken,
+			channel,
 		)
 
+		exportMetrics(ctx, conf.Metrics)
+
 		if err != nil {
 			logger.Fatal(
 				destiny.Describe(
@@ -141,39 +180,109 @@ func main() {
 		return
 	}
 
-	if definedMessenger == messengerMattermost {
+	messengerConfig := conf.Messengers[definedMessenger]
+
+	actionTTL, err := messengerConfig.getActionTTL()
+	if err != nil {
+		logger.Fatal(destiny.Format(err, "can't parse action_ttl"))
+	}
 
+	for _, action := range conf.getActions() {
 		actionContext := context.ContextActionACK{
-			EventID:  eventID,
-			Action:   defaultAction,
-			Severity: severity,
-			Message:  strings.Replace(message, fullEventIDMessage, "", -1),
-			Channel:  channel,
-			Username: conf.Messengers[messengerMattermost].MessengerUsername,
-			IconURL:  icon,
+			EventID:        eventID,
+			Action:         action.Name,
+			Severity:       severity,
+			Message:        strings.Replace(message, fullEventIDMessage, "", -1),
+			Channel:        channel,
+			Username:       messengerConfig.MessengerUsername,
+			IconURL:        icon,
+			ZabbixAction:   action.ZabbixAction,
+			ZabbixSeverity: action.Severity,
+			ZabbixMessage:  action.Message,
 		}
 
-		attachment.AddAction(
-			defaultAction,
-			conf.Actions[defaultAction].ActionURL,
-			defaultActionType,
-			structs.Map(actionContext),
-		)
-	}
+		// chattixd rejects contexts which were changed by the one who
+		// calls the action, if the secret is shared with it
+		if messengerConfig.ActionSecret != "" {
+			actionContext.Sign(
+				messengerConfig.ActionSecret,
+				time.Now().Add(actionTTL),
+			)
+		}
 
-	if definedMessenger == messengerSlack {
-		attachment.AddAction(
-			defaultAction,
-			defaultAction,
-			defaultActionType,
-			eventID,
-		)
+		if definedMessenger == messengerMattermost {
+			attachment.AddAction(
+				action.ActionName,
+				action.ActionURL,
+				defaultActionType,
+				structs.Map(actionContext),
+			)
+		}
+
+		if definedMessenger == messengerSlack {
+			value, err := actionContext.SlackValue()
+			if err != nil {
+				logger.Fatal(
+					destiny.Describe(
+						"action", action.Name,
+					).Format(err, "can't encode action value"),
+				)
+			}
+
+			attachment.AddAction(
+				action.Name,
+				action.ActionName,
+				defaultActionType,
+				value,
+			)
+		}
+
+		if definedMessenger == messengerMatrix {
+			reaction := action.Reaction
+			if reaction == "" && action.Name == defaultAction {
+				reaction = messengerConfig.getAckReaction()
+			}
+
+			if reaction == "" {
+				logger.Warningf(
+					"action %s has no reaction, it's skipped for Matrix",
+					action.Name,
+				)
+				continue
+			}
+
+			// Matrix has no buttons, the action is performed by
+			// reaction and context is kept in the event content
+			attachment.AddAction(
+				action.ActionName,
+				reaction,
+				defaultActionType,
+				actionContext,
+			)
+		}
 	}
 
-	err = request.SendRequest(
+	ref, err := sendMessage(
+		ctx,
+		request,
 		conf.Messengers[definedMessenger].MessengerAPIURL,
 		conf.Messengers[definedMessenger].MessengerAPIToken,
+		channel,
 	)
+
+	if err == nil && eventIDExists {
+		registerEvent(ctx, conf.Chattixd, events.Registration{
+			EventID:   eventID,
+			Messenger: definedMessenger,
+			Channel:   channel,
+			Ref:       ref,
+			Message:   strings.Replace(message, fullEventIDMessage, "", -1),
+			Post:      encodePost(request),
+		})
+	}
+
+	exportMetrics(ctx, conf.Metrics)
+
 	if err != nil {
 		logger.Fatal(
 			destiny.Describe(
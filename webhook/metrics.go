@@ -0,0 +1,90 @@
+package main
+
+import (
+	stdcontext "context"
+
+	karma "github.com/reconquest/karma-go"
+	chat "github.com/zarplata/chattix/chat"
+	"github.com/zarplata/chattix/metrics"
+)
+
+const (
+	defaultMetricsJob = "chattix_webhook"
+
+	deliveryResultOK    = "ok"
+	deliveryResultError = "error"
+)
+
+var (
+	messageDeliveries = metrics.NewCounter(
+		"chattix_message_deliveries_total",
+		"Alerts which are sent to chat by backend, channel and result.",
+		"backend", "channel", "result",
+	)
+
+	lastDelivery = metrics.NewGauge(
+		"chattix_message_last_delivery_timestamp_seconds",
+		"Time of the last alert which is sent to chat.",
+		"backend", "channel", "result",
+	)
+)
+
+// metricsConfig - where metrics of webhook are exported, webhook
+// lives for one alert, so metrics can't be scraped from it
+type metricsConfig struct {
+	// Textfile - file for textfile collector of node_exporter,
+	// counters are accumulated there by every run
+	Textfile string `toml:"textfile"`
+	// PushgatewayURL - Prometheus Pushgateway, metrics of the
+	// last run replace metrics of the job there
+	PushgatewayURL string `toml:"pushgateway_url"`
+	Job            string `toml:"job"`
+}
+
+// sendMessage - send message to chat and count the delivery
+func sendMessage(
+	ctx stdcontext.Context,
+	request chat.Message,
+	url string,
+	token string,
+	channel string,
+) (chat.PostRef, error) {
+	ref, err := request.SendRequest(ctx, url, token)
+
+	result := deliveryResultOK
+	if err != nil {
+		result = deliveryResultError
+	}
+
+	messageDeliveries.WithLabelValues(definedMessenger, channel, result).Inc()
+	lastDelivery.WithLabelValues(definedMessenger, channel, result).SetToCurrentTime()
+
+	return ref, err
+}
+
+// exportMetrics - write metrics to textfile and push them to
+// Pushgateway if it's configured, errors don't fail the alert
+func exportMetrics(ctx stdcontext.Context, config metricsConfig) {
+	if config.Textfile != "" {
+		err := metrics.WriteFile(config.Textfile)
+		if err != nil {
+			logger.Error(
+				karma.Describe(
+					"path", config.Textfile,
+				).Format(err, "can't write metrics"),
+			)
+		}
+	}
+
+	if config.PushgatewayURL != "" {
+		job := config.Job
+		if job == "" {
+			job = defaultMetricsJob
+		}
+
+		err := metrics.Push(ctx, config.PushgatewayURL, job)
+		if err != nil {
+			logger.Error(karma.Format(err, "can't push metrics"))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package zabbix
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/secrets"
+)
+
+const defaultVersionTTL = time.Hour
+
+// Config - settings of access to Zabbix API. Zabbix is accessed by
+// session of user if user is set, static API token is used otherwise.
+type Config struct {
+	URL      string `toml:"zabbix_api_url"`
+	Token    string `toml:"zabbix_api_token"`
+	User     string `toml:"zabbix_user"`
+	Password string `toml:"zabbix_password"`
+	// VersionTTL - how long detected version of Zabbix is kept,
+	// written in format of time.ParseDuration
+	VersionTTL string `toml:"version_ttl"`
+}
+
+// Client - client of Zabbix JSON-RPC API which is safe for
+// concurrent use, session of user is renewed when Zabbix
+// terminates it
+type Client struct {
+	url        string
+	token      string
+	user       string
+	password   string
+	versionTTL time.Duration
+
+	requestID uint64
+
+	mutex   sync.Mutex
+	session string
+
+	versionMutex    sync.Mutex
+	version         Version
+	versionDeadline time.Time
+}
+
+// NewClient - create client from passed config
+func NewClient(config Config) (*Client, error) {
+	versionTTL := defaultVersionTTL
+
+	if config.VersionTTL != "" {
+		var err error
+
+		versionTTL, err = time.ParseDuration(config.VersionTTL)
+		if err != nil {
+			return nil, karma.Describe(
+				"version_ttl", config.VersionTTL,
+			).Reason(err)
+		}
+	}
+
+	return &Client{
+		url:        config.URL,
+		token:      config.Token,
+		user:       config.User,
+		password:   config.Password,
+		versionTTL: versionTTL,
+	}, nil
+}
+
+// URL - address of Zabbix API
+func (client *Client) URL() string {
+	return client.url
+}
+
+// hasCredentials - whether session is made by user.login,
+// credentials take precedence over static API token
+func (client *Client) hasCredentials() bool {
+	return client.user != ""
+}
+
+func (client *Client) nextRequestID() uint64 {
+	return atomic.AddUint64(&client.requestID, 1)
+}
+
+// Call - call method of Zabbix API and decode result into passed
+// value, session is renewed once if Zabbix tells that it's terminated
+func (client *Client) Call(
+	ctx context.Context,
+	method string,
+	params interface{},
+	result interface{},
+) error {
+	version, err := client.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	token, err := client.auth(ctx)
+	if err != nil {
+		return err
+	}
+
+	auth := auth{
+		token:  token,
+		bearer: version.usesBearer(),
+	}
+
+	err = client.do(ctx, auth, method, params, result)
+	if err == nil || !client.hasCredentials() || !IsSessionTerminated(err) {
+		return err
+	}
+
+	client.invalidate(token)
+
+	auth.token, err = client.auth(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.do(ctx, auth, method, params, result)
+}
+
+// Version - version of Zabbix API, it's cached for version TTL
+// because every request depends on it
+func (client *Client) Version(ctx context.Context) (Version, error) {
+	client.versionMutex.Lock()
+	defer client.versionMutex.Unlock()
+
+	if time.Now().Before(client.versionDeadline) {
+		return client.version, nil
+	}
+
+	version, err := client.fetchVersion(ctx)
+	if err != nil {
+		return Version{}, err
+	}
+
+	client.version = version
+	client.versionDeadline = time.Now().Add(client.versionTTL)
+
+	return version, nil
+}
+
+func (client *Client) fetchVersion(ctx context.Context) (Version, error) {
+	var value string
+
+	// apiinfo.version must be called without auth
+	err := client.do(
+		ctx,
+		auth{},
+		"apiinfo.version",
+		map[string]interface{}{},
+		&value,
+	)
+	if err != nil {
+		return Version{}, err
+	}
+
+	return ParseVersion(value)
+}
+
+// Check - make sure that Zabbix API is reachable and accepts token
+// or credentials of client, version is requested bypassing the cache
+func (client *Client) Check(ctx context.Context) (Version, error) {
+	version, err := client.fetchVersion(ctx)
+	if err != nil {
+		return Version{}, karma.Format(err, "Zabbix API is not reachable")
+	}
+
+	err = client.Call(
+		ctx,
+		"user.get",
+		map[string]interface{}{
+			"output": []string{"userid"},
+			"limit":  1,
+		},
+		nil,
+	)
+	if err != nil {
+		return version, karma.Format(err, "can't authenticate in Zabbix API")
+	}
+
+	return version, nil
+}
+
+// auth - return API token or session of logged in user,
+// user is logged in if there is no session yet
+func (client *Client) auth(ctx context.Context) (string, error) {
+	if !client.hasCredentials() {
+		return client.token, nil
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.session != "" {
+		return client.session, nil
+	}
+
+	session, err := client.login(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	client.session = session
+
+	secrets.Register(session)
+
+	return session, nil
+}
+
+// invalidate - forget session if it wasn't renewed by
+// another request yet
+func (client *Client) invalidate(session string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.session == session {
+		client.session = ""
+	}
+}
+
+func (client *Client) login(ctx context.Context) (string, error) {
+	destiny := karma.Describe(
+		"method", "login",
+	).Describe(
+		"user", client.user,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return "", destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't get Zabbix version",
+		)
+	}
+
+	var session string
+
+	err = client.do(
+		ctx,
+		auth{},
+		"user.login",
+		map[string]interface{}{
+			version.userLoginParam(): client.user,
+			"password":               client.password,
+		},
+		&session,
+	)
+	if err != nil {
+		return "", destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't login to Zabbix",
+		)
+	}
+
+	return session, nil
+}
+
+// Logout - terminate session of logged in user, nothing is
+// done for static API token
+func (client *Client) Logout(ctx context.Context) error {
+	if !client.hasCredentials() {
+		return nil
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.session == "" {
+		return nil
+	}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = client.do(
+		ctx,
+		auth{
+			token:  client.session,
+			bearer: version.usesBearer(),
+		},
+		"user.logout",
+		[]string{},
+		nil,
+	)
+	if err != nil {
+		return karma.Describe(
+			"method", "Logout",
+		).Describe(
+			"user", client.user,
+		).Describe(
+			"error", err,
+		).Reason(
+			"can't logout from Zabbix",
+		)
+	}
+
+	client.session = ""
+
+	return nil
+}
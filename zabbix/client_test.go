@@ -0,0 +1,476 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+// fakeRequest - request which is received by fake Zabbix
+type fakeRequest struct {
+	Method        string
+	Params        map[string]interface{}
+	Auth          string
+	Authorization string
+}
+
+// fakeZabbix - JSON-RPC server which answers like Zabbix
+// of passed version
+type fakeZabbix struct {
+	version string
+
+	mutex    sync.Mutex
+	requests []fakeRequest
+	logins   int
+	// expired - sessions which are terminated by Zabbix
+	expired map[string]bool
+}
+
+func newFakeZabbix(t *testing.T, version string) (*fakeZabbix, *Client) {
+	fake := &fakeZabbix{
+		version: version,
+		expired: map[string]bool{},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Config{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, client
+}
+
+func (fake *fakeZabbix) serve(writer http.ResponseWriter, request *http.Request) {
+	var payload struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Auth   string          `json:"auth"`
+		ID     uint64          `json:"id"`
+	}
+
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	received := fakeRequest{
+		Method:        payload.Method,
+		Auth:          payload.Auth,
+		Authorization: request.Header.Get("Authorization"),
+	}
+
+	_ = json.Unmarshal(payload.Params, &received.Params)
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.requests = append(fake.requests, received)
+
+	response := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      payload.ID,
+	}
+
+	token := received.Auth
+	if token == "" {
+		token = strings.TrimPrefix(received.Authorization, "Bearer ")
+	}
+
+	switch payload.Method {
+	case "apiinfo.version":
+		response["result"] = fake.version
+	case "user.login":
+		fake.logins++
+		response["result"] = fmt.Sprintf("session-%d", fake.logins)
+	default:
+		if fake.expired[token] {
+			response["error"] = map[string]interface{}{
+				"code":    -32602,
+				"message": "Invalid params.",
+				"data":    "Session terminated, re-login, please.",
+			}
+			break
+		}
+
+		response["result"] = []interface{}{}
+	}
+
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+// last - the last received request of method
+func (fake *fakeZabbix) last(t *testing.T, method string) fakeRequest {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for i := len(fake.requests) - 1; i >= 0; i-- {
+		if fake.requests[i].Method == method {
+			return fake.requests[i]
+		}
+	}
+
+	t.Fatalf("%s is not requested", method)
+
+	return fakeRequest{}
+}
+
+func (fake *fakeZabbix) count(method string) int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	count := 0
+	for _, request := range fake.requests {
+		if request.Method == method {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestClient_Call_PassesTokenByVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		bearer  bool
+	}{
+		{"3.4.15", false},
+		{"5.0.30", false},
+		{"6.4.0", true},
+		{"7.0.2", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			fake, client := newFakeZabbix(t, test.version)
+			client.token = "api-token"
+
+			err := client.Call(context.Background(), "user.get", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			version := fake.last(t, "apiinfo.version")
+			if version.Auth != "" || version.Authorization != "" {
+				t.Errorf("apiinfo.version is called with auth: %+v", version)
+			}
+
+			request := fake.last(t, "user.get")
+
+			if test.bearer {
+				if request.Auth != "" {
+					t.Errorf("auth field is passed: %q", request.Auth)
+				}
+
+				if request.Authorization != "Bearer api-token" {
+					t.Errorf(
+						"unexpected Authorization header: %q",
+						request.Authorization,
+					)
+				}
+			} else {
+				if request.Auth != "api-token" {
+					t.Errorf("unexpected auth field: %q", request.Auth)
+				}
+
+				if request.Authorization != "" {
+					t.Errorf(
+						"Authorization header is passed: %q",
+						request.Authorization,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Call_LogsInByVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		param   string
+		bearer  bool
+	}{
+		{"3.4.15", "user", false},
+		{"5.0.30", "user", false},
+		{"5.4.0", "username", false},
+		{"6.4.0", "username", true},
+		{"7.0.2", "username", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			fake, client := newFakeZabbix(t, test.version)
+			client.user = "chattix"
+			client.password = "password"
+
+			err := client.Call(context.Background(), "user.get", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			login := fake.last(t, "user.login")
+
+			if login.Params[test.param] != "chattix" {
+				t.Errorf(
+					"user is not passed as %s: %v",
+					test.param,
+					login.Params,
+				)
+			}
+
+			for _, param := range []string{"user", "username"} {
+				if param != test.param && login.Params[param] != nil {
+					t.Errorf("user is passed as %s: %v", param, login.Params)
+				}
+			}
+
+			if login.Auth != "" || login.Authorization != "" {
+				t.Errorf("user.login is called with auth: %+v", login)
+			}
+
+			request := fake.last(t, "user.get")
+
+			token := request.Auth
+			if test.bearer {
+				token = strings.TrimPrefix(request.Authorization, "Bearer ")
+			}
+
+			if token != "session-1" {
+				t.Errorf("session is not passed: %+v", request)
+			}
+		})
+	}
+}
+
+func TestClient_Call_LogsInAgainOnceWhenSessionIsTerminated(t *testing.T) {
+	fake, client := newFakeZabbix(t, "6.0.0")
+	client.user = "chattix"
+	client.password = "password"
+
+	ctx := context.Background()
+
+	err := client.Call(ctx, "user.get", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mutex.Lock()
+	fake.expired["session-1"] = true
+	fake.mutex.Unlock()
+
+	err = client.Call(ctx, "user.get", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logins := fake.count("user.login"); logins != 2 {
+		t.Errorf("expected 2 logins, got %d", logins)
+	}
+
+	if requests := fake.count("user.get"); requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	if token := fake.last(t, "user.get").Auth; token != "session-2" {
+		t.Errorf("request is repeated with %q", token)
+	}
+}
+
+func TestClient_Call_DoesNotLogInAgainInLoop(t *testing.T) {
+	fake, client := newFakeZabbix(t, "6.0.0")
+	client.user = "chattix"
+	client.password = "password"
+
+	ctx := context.Background()
+
+	err := client.Call(ctx, "user.get", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mutex.Lock()
+	// the next session is terminated right away too
+	fake.expired["session-1"] = true
+	fake.expired["session-2"] = true
+	fake.mutex.Unlock()
+
+	err = client.Call(ctx, "user.get", nil, nil)
+	if !IsSessionTerminated(err) {
+		t.Fatalf("expected terminated session, got %v", err)
+	}
+
+	if logins := fake.count("user.login"); logins != 2 {
+		t.Errorf("expected 2 logins, got %d", logins)
+	}
+}
+
+func TestClient_Call_DoesNotLogInAgainWithToken(t *testing.T) {
+	fake, client := newFakeZabbix(t, "6.0.0")
+	client.token = "api-token"
+
+	fake.expired["api-token"] = true
+
+	err := client.Call(context.Background(), "user.get", nil, nil)
+	if !IsSessionTerminated(err) {
+		t.Fatalf("expected terminated session, got %v", err)
+	}
+
+	if logins := fake.count("user.login"); logins != 0 {
+		t.Errorf("expected no logins, got %d", logins)
+	}
+}
+
+func TestClient_Acknowledge_ActionByVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		version         string
+		acknowledgement Acknowledgement
+		action          int
+		unsupported     bool
+		params          map[string]interface{}
+	}{
+		{
+			name:            "default",
+			version:         "3.4.15",
+			acknowledgement: Acknowledgement{},
+			action:          1,
+		},
+		{
+			name:            "message",
+			version:         "3.4.15",
+			acknowledgement: Acknowledgement{Action: ActionMessage},
+			action:          0,
+		},
+		{
+			name:            "close",
+			version:         "3.4.15",
+			acknowledgement: Acknowledgement{Close: true},
+			action:          1,
+		},
+		{
+			name:            "close action",
+			version:         "3.4.15",
+			acknowledgement: Acknowledgement{Action: ActionClose | ActionMessage},
+			action:          1,
+		},
+		{
+			name:            "unacknowledge",
+			version:         "3.4.15",
+			acknowledgement: Acknowledgement{Action: ActionUnacknowledge},
+			unsupported:     true,
+		},
+		{
+			name:            "default",
+			version:         "5.0.30",
+			acknowledgement: Acknowledgement{},
+			action:          ActionAcknowledge | ActionMessage,
+		},
+		{
+			name:            "unacknowledge",
+			version:         "5.0.30",
+			acknowledgement: Acknowledgement{Action: ActionUnacknowledge},
+			action:          ActionUnacknowledge,
+		},
+		{
+			name:            "suppress",
+			version:         "5.0.30",
+			acknowledgement: Acknowledgement{Action: ActionSuppress},
+			unsupported:     true,
+		},
+		{
+			name:            "suppress",
+			version:         "6.4.0",
+			acknowledgement: Acknowledgement{Action: ActionSuppress},
+			action:          ActionSuppress,
+			params:          map[string]interface{}{"suppress_until": 0.0},
+		},
+		{
+			name:    "close with severity",
+			version: "7.0.2",
+			acknowledgement: Acknowledgement{
+				Close:    true,
+				Severity: "4",
+			},
+			action: ActionClose | ActionAcknowledge |
+				ActionMessage | ActionChangeSeverity,
+			params: map[string]interface{}{"severity": "4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version+" "+test.name, func(t *testing.T) {
+			fake, client := newFakeZabbix(t, test.version)
+			client.token = "api-token"
+
+			acknowledgement := test.acknowledgement
+			acknowledgement.EventID = "42"
+			acknowledgement.Message = "on it"
+
+			err := client.Acknowledge(context.Background(), acknowledgement)
+
+			if test.unsupported {
+				if !karma.Contains(err, ErrUnsupportedAction) {
+					t.Fatalf("expected unsupported action, got %v", err)
+				}
+
+				if count := fake.count("event.acknowledge"); count != 0 {
+					t.Errorf("event.acknowledge is called %d times", count)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := fake.last(t, "event.acknowledge")
+
+			if request.Params["action"] != float64(test.action) {
+				t.Errorf(
+					"expected action %d, got %v",
+					test.action,
+					request.Params["action"],
+				)
+			}
+
+			for key, value := range test.params {
+				if request.Params[key] != value {
+					t.Errorf(
+						"expected %s = %v, got %v",
+						key,
+						value,
+						request.Params[key],
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Acknowledge_SeverityIsNotChangedByZabbix3(t *testing.T) {
+	fake, client := newFakeZabbix(t, "3.4.15")
+	client.token = "api-token"
+
+	err := client.Acknowledge(context.Background(), Acknowledgement{
+		EventID:  "42",
+		Severity: "4",
+	})
+	if err == nil {
+		t.Fatal("severity is changed by Zabbix 3.x")
+	}
+
+	if count := fake.count("event.acknowledge"); count != 0 {
+		t.Errorf("event.acknowledge is called %d times", count)
+	}
+}
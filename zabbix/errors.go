@@ -0,0 +1,61 @@
+package zabbix
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+// ErrUnsupportedAction - action of event.acknowledge is not
+// known by the version of Zabbix
+var ErrUnsupportedAction = errors.New(
+	"action is not supported by this version of Zabbix",
+)
+
+// Error - error of JSON-RPC request which is returned by Zabbix
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+	// Method - method of Zabbix API which returned the error
+	Method string `json:"-"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf(
+		"zabbix %s: %s %s (code %d)",
+		err.Method,
+		err.Message,
+		err.Data,
+		err.Code,
+	)
+}
+
+// IsSessionTerminated - Zabbix answers with "Session terminated"
+// for expired session and with "Not authorised" since 5.4
+func (err *Error) IsSessionTerminated() bool {
+	return strings.Contains(err.Data, "Session terminated") ||
+		strings.Contains(err.Data, "re-login") ||
+		strings.Contains(err.Data, "Not authorised")
+}
+
+// GetError - find error returned by Zabbix in chain of reasons
+func GetError(err error) (*Error, bool) {
+	var apiError *Error
+
+	if err == nil || !karma.Find(err, &apiError) {
+		return nil, false
+	}
+
+	return apiError, true
+}
+
+// IsSessionTerminated - whether session of user should be renewed
+// to repeat the request
+func IsSessionTerminated(err error) bool {
+	apiError, ok := GetError(err)
+
+	return ok && apiError.IsSessionTerminated()
+}
@@ -0,0 +1,510 @@
+package zabbix
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+// Bits of action parameter of event.acknowledge request
+const (
+	ActionClose          = 1
+	ActionAcknowledge    = 2
+	ActionMessage        = 4
+	ActionChangeSeverity = 8
+	ActionUnacknowledge  = 16
+	// since Zabbix 6.4
+	ActionSuppress          = 32
+	ActionUnsuppress        = 64
+	ActionChangeRankToCause = 128
+)
+
+// Acknowledgement - what should be done with Zabbix event
+type Acknowledgement struct {
+	EventID string
+	Message string
+	// Action - bitmask of event.acknowledge action, zero means
+	// acknowledgement with message
+	Action int
+	// Close - close the problem together with acknowledgement
+	Close bool
+	// Severity - new severity of event, empty to keep it
+	Severity string
+}
+
+// Acknowledge - perform acknowledgement of event with parameters
+// which are supported by the version of Zabbix
+func (client *Client) Acknowledge(
+	ctx context.Context,
+	acknowledgement Acknowledgement,
+) error {
+	destiny := karma.Describe(
+		"method", "Acknowledge",
+	).Describe(
+		"eventID", acknowledgement.EventID,
+	)
+
+	params := map[string]interface{}{
+		"eventids": acknowledgement.EventID,
+		"message":  acknowledgement.Message,
+	}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't get Zabbix version",
+		)
+	}
+
+	destiny = destiny.Describe(
+		"zabbix version", version,
+	)
+
+	//https://www.zabbix.com/documentation/3.4/manual/api/reference/event/acknowledge
+	if !version.AtLeast(4, 0) {
+		if acknowledgement.Severity != "" {
+			return destiny.Reason(
+				"severity can't be changed by Zabbix 3.x",
+			)
+		}
+
+		// Zabbix 3.x can only acknowledge with message or close
+		// the problem, other actions would turn into acknowledgement
+		unsupported := acknowledgement.Action &^ version.acknowledgeActions()
+		if unsupported != 0 {
+			return destiny.Describe(
+				"action", acknowledgement.Action,
+			).Describe(
+				"unsupported bits", unsupported,
+			).Reason(
+				ErrUnsupportedAction,
+			)
+		}
+
+		// action is 0 to acknowledge event and 1 to close
+		// problem, both of them acknowledge event with message.
+		// Default acknowledgement has always been sent with 1,
+		// so it's kept for existing setups.
+		params["action"] = 1
+
+		if acknowledgement.Action != 0 &&
+			acknowledgement.Action&ActionClose == 0 &&
+			!acknowledgement.Close {
+			params["action"] = 0
+		}
+	} else {
+		//https://www.zabbix.com/documentation/4.0/manual/api/reference/event/acknowledge
+		//https://www.zabbix.com/documentation/6.4/manual/api/reference/event/acknowledge
+		action := acknowledgement.Action
+		if action == 0 {
+			action = ActionAcknowledge | ActionMessage
+		}
+
+		if action&ActionMessage == 0 {
+			delete(params, "message")
+		}
+
+		if acknowledgement.Close {
+			action |= ActionClose
+		}
+
+		if acknowledgement.Severity != "" {
+			action |= ActionChangeSeverity
+			params["severity"] = acknowledgement.Severity
+		}
+
+		if unsupported := action &^ version.acknowledgeActions(); unsupported != 0 {
+			return destiny.Describe(
+				"action", action,
+			).Describe(
+				"unsupported bits", unsupported,
+			).Reason(
+				ErrUnsupportedAction,
+			)
+		}
+
+		// problem is suppressed until it's unsuppressed manually
+		if action&ActionSuppress != 0 {
+			params["suppress_until"] = 0
+		}
+
+		params["action"] = action
+	}
+
+	err = client.Call(
+		ctx,
+		"event.acknowledge",
+		params,
+		nil,
+	)
+	if err != nil {
+		return destiny.Reason(err)
+	}
+
+	return nil
+}
+
+// EventAcknowledge - update of event by user, e.g. acknowledgement
+// or change of severity
+type EventAcknowledge struct {
+	UserID  string `json:"userid"`
+	Clock   string `json:"clock"`
+	Action  string `json:"action"`
+	Message string `json:"message"`
+	// Username - login of user since Zabbix 5.4
+	Username string `json:"username"`
+	// Alias - login of user before Zabbix 5.4
+	Alias   string `json:"alias"`
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+}
+
+// Time - when event was updated
+func (acknowledge *EventAcknowledge) Time() time.Time {
+	clock, err := strconv.ParseInt(acknowledge.Clock, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(clock, 0)
+}
+
+// UserName - full name of user who updated event, login is
+// returned if user has no name
+func (acknowledge *EventAcknowledge) UserName() string {
+	name := strings.TrimSpace(acknowledge.Name + " " + acknowledge.Surname)
+	if name != "" {
+		return name
+	}
+
+	if acknowledge.Username != "" {
+		return acknowledge.Username
+	}
+
+	return acknowledge.Alias
+}
+
+// GetAcknowledge - update which has acknowledged the event, nil is
+// returned if event is not acknowledged. Acknowledgements made before
+// the last unacknowledgement are ignored.
+func (client *Client) GetAcknowledge(
+	ctx context.Context,
+	eventID string,
+) (*EventAcknowledge, error) {
+	destiny := karma.Describe(
+		"method", "GetAcknowledge",
+	).Describe(
+		"eventID", eventID,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	events := []struct {
+		Acknowledged string              `json:"acknowledged"`
+		Acknowledges []*EventAcknowledge `json:"acknowledges"`
+	}{}
+
+	err = client.Call(
+		ctx,
+		"event.get",
+		map[string]interface{}{
+			"output":              []string{"eventid", "acknowledged"},
+			"eventids":            []string{eventID},
+			"select_acknowledges": "extend",
+		},
+		&events,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	if len(events) == 0 {
+		return nil, destiny.Reason("event is not found")
+	}
+
+	if events[0].Acknowledged != "1" {
+		return nil, nil
+	}
+
+	return firstAcknowledge(version, events[0].Acknowledges), nil
+}
+
+// firstAcknowledge - update which has acknowledged the event after
+// the last unacknowledgement, acknowledges are sorted by the way
+func firstAcknowledge(
+	version Version,
+	acknowledges []*EventAcknowledge,
+) *EventAcknowledge {
+	// updates are returned in reverse chronological order
+	sort.SliceStable(acknowledges, func(i, j int) bool {
+		return acknowledges[i].Time().Before(acknowledges[j].Time())
+	})
+
+	var first *EventAcknowledge
+
+	for _, acknowledge := range acknowledges {
+		// every update of Zabbix 3.x is an acknowledgement
+		if !version.AtLeast(4, 0) {
+			first = acknowledge
+			break
+		}
+
+		action, err := strconv.Atoi(acknowledge.Action)
+		if err != nil {
+			continue
+		}
+
+		if action&ActionUnacknowledge != 0 {
+			first = nil
+			continue
+		}
+
+		if first == nil && action&ActionAcknowledge != 0 {
+			first = acknowledge
+		}
+	}
+
+	// updates can be removed by housekeeper while
+	// event is still acknowledged
+	if first == nil {
+		first = &EventAcknowledge{}
+	}
+
+	return first
+}
+
+// closeAcknowledge - update which has closed the problem manually,
+// nil is returned if there is no such update
+func closeAcknowledge(acknowledges []*EventAcknowledge) *EventAcknowledge {
+	for _, acknowledge := range acknowledges {
+		action, err := strconv.Atoi(acknowledge.Action)
+		if err != nil {
+			continue
+		}
+
+		if action&ActionClose != 0 {
+			return acknowledge
+		}
+	}
+
+	return nil
+}
+
+// EventState - state of problem event which can be changed
+// outside of chat
+type EventState struct {
+	EventID  string `json:"eventid"`
+	Severity string `json:"severity"`
+	// Acknowledged - "1" if event is acknowledged
+	Acknowledged string `json:"acknowledged"`
+	// REventID - recovery event, "0" if problem is not resolved
+	REventID string `json:"r_eventid"`
+	// UserID - user who has closed the problem manually, "0" if
+	// problem is not closed or is resolved by recovery expression
+	UserID       string              `json:"userid"`
+	Acknowledges []*EventAcknowledge `json:"acknowledges"`
+
+	// Acknowledge - update which has acknowledged the event, nil
+	// if event is not acknowledged
+	Acknowledge *EventAcknowledge `json:"-"`
+	// Close - update which has closed the problem, nil if problem
+	// isn't closed manually or Zabbix doesn't keep the update
+	Close *EventAcknowledge `json:"-"`
+	// ResolvedAt - time of recovery event, zero if problem is not
+	// resolved or recovery event is not found
+	ResolvedAt time.Time `json:"-"`
+}
+
+// IsAcknowledged - whether event is acknowledged
+func (state *EventState) IsAcknowledged() bool {
+	return state.Acknowledged == "1"
+}
+
+// IsResolved - whether problem is resolved or closed
+func (state *EventState) IsResolved() bool {
+	return state.REventID != "" && state.REventID != "0"
+}
+
+// IsClosedManually - whether problem is closed by user instead
+// of recovery expression
+func (state *EventState) IsClosedManually() bool {
+	return state.IsResolved() && state.UserID != "" && state.UserID != "0"
+}
+
+// GetEventStates - return states of events with their updates and
+// time of recovery, events which are not found, e.g. removed by
+// housekeeper, are skipped
+func (client *Client) GetEventStates(
+	ctx context.Context,
+	eventIDs []string,
+) ([]*EventState, error) {
+	destiny := karma.Describe(
+		"method", "GetEventStates",
+	).Describe(
+		"eventIDs", eventIDs,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	states := []*EventState{}
+
+	err = client.Call(
+		ctx,
+		"event.get",
+		map[string]interface{}{
+			"output": []string{
+				"eventid",
+				"severity",
+				"acknowledged",
+				"r_eventid",
+				"userid",
+			},
+			"eventids":            eventIDs,
+			"select_acknowledges": "extend",
+		},
+		&states,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	recoveryIDs := []string{}
+
+	for _, state := range states {
+		if state.IsAcknowledged() {
+			state.Acknowledge = firstAcknowledge(version, state.Acknowledges)
+		}
+
+		if state.IsClosedManually() {
+			state.Close = closeAcknowledge(state.Acknowledges)
+		}
+
+		if state.IsResolved() {
+			recoveryIDs = append(recoveryIDs, state.REventID)
+		}
+	}
+
+	if len(recoveryIDs) == 0 {
+		return states, nil
+	}
+
+	recoveries := []struct {
+		EventID string `json:"eventid"`
+		Clock   string `json:"clock"`
+	}{}
+
+	err = client.Call(
+		ctx,
+		"event.get",
+		map[string]interface{}{
+			"output":   []string{"eventid", "clock"},
+			"eventids": recoveryIDs,
+		},
+		&recoveries,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	clocks := map[string]time.Time{}
+	for _, recovery := range recoveries {
+		clock, err := strconv.ParseInt(recovery.Clock, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		clocks[recovery.EventID] = time.Unix(clock, 0)
+	}
+
+	for _, state := range states {
+		if state.IsResolved() {
+			state.ResolvedAt = clocks[state.REventID]
+		}
+	}
+
+	return states, nil
+}
+
+// GetEvent - return event with severity, hosts and groups of hosts
+func (client *Client) GetEvent(
+	ctx context.Context,
+	eventID string,
+) (*Event, error) {
+	destiny := karma.Describe(
+		"method", "GetEvent",
+	).Describe(
+		"eventID", eventID,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	events := []*Event{}
+
+	err = client.Call(
+		ctx,
+		"event.get",
+		map[string]interface{}{
+			"output":      []string{"eventid", "name", "severity"},
+			"eventids":    []string{eventID},
+			"selectHosts": []string{"hostid"},
+		},
+		&events,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	if len(events) == 0 {
+		return nil, destiny.Reason("event is not found")
+	}
+
+	event := events[0]
+	if len(event.Hosts) == 0 {
+		return event, nil
+	}
+
+	// event.get can't return groups of hosts
+	hostIDs := []string{}
+	for _, host := range event.Hosts {
+		hostIDs = append(hostIDs, host.HostID)
+	}
+
+	hosts := []*Host{}
+
+	err = client.Call(
+		ctx,
+		"host.get",
+		map[string]interface{}{
+			"output":                  []string{"hostid", "host", "name"},
+			"hostids":                 hostIDs,
+			version.hostGroupsParam(): []string{"groupid", "name"},
+		},
+		&hosts,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	for _, host := range hosts {
+		host.normalize()
+	}
+
+	event.Hosts = hosts
+
+	return event, nil
+}
@@ -0,0 +1,360 @@
+package zabbix
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+// ProblemsLimit - how many latest problems are returned
+const ProblemsLimit = 20
+
+// Problem - problem of trigger which is returned by problem.get
+type Problem struct {
+	EventID      string `json:"eventid"`
+	ObjectID     string `json:"objectid"`
+	Clock        string `json:"clock"`
+	Name         string `json:"name"`
+	Severity     string `json:"severity"`
+	Acknowledged string `json:"acknowledged"`
+	Hosts        []*Host
+}
+
+// Event - event with hosts where it happened
+type Event struct {
+	EventID  string  `json:"eventid"`
+	Name     string  `json:"name"`
+	Severity string  `json:"severity"`
+	Hosts    []*Host `json:"hosts"`
+}
+
+// Host - monitored host
+type Host struct {
+	HostID string       `json:"hostid"`
+	Host   string       `json:"host"`
+	Name   string       `json:"name"`
+	Status string       `json:"status"`
+	Groups []*HostGroup `json:"groups"`
+	// HostGroups - groups of host since Zabbix 6.2
+	HostGroups []*HostGroup `json:"hostgroups"`
+	Interfaces []struct {
+		IP  string `json:"ip"`
+		DNS string `json:"dns"`
+	} `json:"interfaces"`
+}
+
+// normalize - keep groups of host in the same field
+// regardless of Zabbix version
+func (host *Host) normalize() {
+	if len(host.HostGroups) > 0 {
+		host.Groups = host.HostGroups
+		host.HostGroups = nil
+	}
+}
+
+// HostGroup - group of hosts
+type HostGroup struct {
+	GroupID string `json:"groupid"`
+	Name    string `json:"name"`
+}
+
+// ProblemFilter - which problems should be returned,
+// empty filter means all problems
+type ProblemFilter struct {
+	HostIDs  []string
+	GroupIDs []string
+}
+
+// GetProblems - return the latest problems with hosts
+// where they happened
+func (client *Client) GetProblems(
+	ctx context.Context,
+	filter ProblemFilter,
+) ([]*Problem, error) {
+	destiny := karma.Describe(
+		"method", "GetProblems",
+	)
+
+	params := map[string]interface{}{
+		"output":    "extend",
+		"sortfield": []string{"eventid"},
+		"sortorder": "DESC",
+		"limit":     ProblemsLimit,
+	}
+
+	if len(filter.HostIDs) > 0 {
+		params["hostids"] = filter.HostIDs
+	}
+
+	if len(filter.GroupIDs) > 0 {
+		params["groupids"] = filter.GroupIDs
+	}
+
+	problems := []*Problem{}
+
+	err := client.Call(
+		ctx,
+		"problem.get",
+		params,
+		&problems,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	if len(problems) == 0 {
+		return problems, nil
+	}
+
+	// problem.get can't return hosts, so they are taken
+	// from events of problems
+	eventIDs := []string{}
+	for _, problem := range problems {
+		eventIDs = append(eventIDs, problem.EventID)
+	}
+
+	events := []*Event{}
+
+	err = client.Call(
+		ctx,
+		"event.get",
+		map[string]interface{}{
+			"output":      []string{"eventid"},
+			"eventids":    eventIDs,
+			"selectHosts": []string{"hostid", "host", "name"},
+		},
+		&events,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	hosts := map[string][]*Host{}
+	for _, event := range events {
+		hosts[event.EventID] = event.Hosts
+	}
+
+	for _, problem := range problems {
+		problem.Hosts = hosts[problem.EventID]
+	}
+
+	return problems, nil
+}
+
+// FindHosts - return hosts which visible name or
+// technical name matches passed name
+func (client *Client) FindHosts(
+	ctx context.Context,
+	name string,
+) ([]*Host, error) {
+	destiny := karma.Describe(
+		"method", "FindHosts",
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	hosts := []*Host{}
+
+	err = client.Call(
+		ctx,
+		"host.get",
+		map[string]interface{}{
+			"output": []string{"hostid", "host", "name", "status"},
+			"search": map[string]interface{}{
+				"host": name,
+				"name": name,
+			},
+			"searchByAny":             true,
+			version.hostGroupsParam(): []string{"groupid", "name"},
+			"selectInterfaces":        []string{"ip", "dns"},
+			"limit":                   ProblemsLimit,
+		},
+		&hosts,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	for _, host := range hosts {
+		host.normalize()
+	}
+
+	return hosts, nil
+}
+
+// GetHost - return host with exactly passed technical or visible name,
+// nil is returned if there is no such host
+func (client *Client) GetHost(
+	ctx context.Context,
+	name string,
+) (*Host, error) {
+	destiny := karma.Describe(
+		"method", "GetHost",
+	).Describe(
+		"host", name,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	// both names are unique in Zabbix, but filter by
+	// several fields matches all of them at once
+	for _, field := range []string{"host", "name"} {
+		hosts := []*Host{}
+
+		err = client.Call(
+			ctx,
+			"host.get",
+			map[string]interface{}{
+				"output": []string{"hostid", "host", "name", "status"},
+				"filter": map[string]interface{}{
+					field: []string{name},
+				},
+				version.hostGroupsParam(): []string{"groupid", "name"},
+				"selectInterfaces":        []string{"ip", "dns"},
+			},
+			&hosts,
+		)
+		if err != nil {
+			return nil, destiny.Reason(err)
+		}
+
+		if len(hosts) > 0 {
+			hosts[0].normalize()
+			return hosts[0], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// FindHostGroups - return host groups with passed name
+func (client *Client) FindHostGroups(
+	ctx context.Context,
+	name string,
+) ([]*HostGroup, error) {
+	groups := []*HostGroup{}
+
+	err := client.Call(
+		ctx,
+		"hostgroup.get",
+		map[string]interface{}{
+			"output": []string{"groupid", "name"},
+			"filter": map[string]interface{}{
+				"name": []string{name},
+			},
+		},
+		&groups,
+	)
+	if err != nil {
+		return nil, karma.Describe(
+			"method", "FindHostGroups",
+		).Reason(err)
+	}
+
+	return groups, nil
+}
+
+// CreateMaintenance - put host into maintenance with
+// data collection for passed duration
+func (client *Client) CreateMaintenance(
+	ctx context.Context,
+	host *Host,
+	duration time.Duration,
+	description string,
+) error {
+	destiny := karma.Describe(
+		"method", "CreateMaintenance",
+	).Describe(
+		"host", host.Host,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't get Zabbix version",
+		)
+	}
+
+	now := time.Now()
+	activeTill := now.Add(duration)
+
+	params := map[string]interface{}{
+		"name": fmt.Sprintf(
+			"chattix: %s till %s",
+			host.Host,
+			activeTill.Format("2006-01-02 15:04:05"),
+		),
+		"active_since": now.Unix(),
+		"active_till":  activeTill.Unix(),
+		"description":  description,
+		"timeperiods": []map[string]interface{}{
+			{
+				"timeperiod_type": 0,
+				"start_date":      now.Unix(),
+				"period":          int64(duration.Seconds()),
+			},
+		},
+	}
+
+	//https://www.zabbix.com/documentation/6.0/manual/api/reference/maintenance/create
+	if version.maintenanceHostsParam() == "hosts" {
+		params["hosts"] = []map[string]string{
+			{"hostid": host.HostID},
+		}
+	} else {
+		params["hostids"] = []string{host.HostID}
+	}
+
+	err = client.Call(
+		ctx,
+		"maintenance.create",
+		params,
+		nil,
+	)
+	if err != nil {
+		return destiny.Reason(err)
+	}
+
+	return nil
+}
+
+// CanEditHosts - whether user of client has read-write
+// permission on all passed hosts
+func (client *Client) CanEditHosts(
+	ctx context.Context,
+	hostIDs []string,
+) (bool, error) {
+	if len(hostIDs) == 0 {
+		return true, nil
+	}
+
+	hosts := []*Host{}
+
+	err := client.Call(
+		ctx,
+		"host.get",
+		map[string]interface{}{
+			"output":   []string{"hostid"},
+			"hostids":  hostIDs,
+			"editable": true,
+		},
+		&hosts,
+	)
+	if err != nil {
+		return false, karma.Describe(
+			"method", "CanEditHosts",
+		).Reason(err)
+	}
+
+	return len(hosts) == len(hostIDs), nil
+}
@@ -0,0 +1,190 @@
+package zabbix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+	"github.com/zarplata/chattix/metrics"
+	"github.com/zarplata/chattix/secrets"
+	"github.com/zarplata/chattix/transport"
+)
+
+var requestDuration = metrics.NewHistogram(
+	"chattix_zabbix_request_duration_seconds",
+	"Latency of requests to Zabbix API.",
+	metrics.DefaultBuckets,
+	"method", "result",
+)
+
+// Request - JSON-RPC request to Zabbix API
+type Request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Auth    string      `json:"auth,omitempty"`
+	ID      uint64      `json:"id"`
+}
+
+// Response - JSON-RPC response of Zabbix API, result is decoded
+// by the caller because it depends on method
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Error   *Error          `json:"error"`
+	Result  json.RawMessage `json:"result"`
+	ID      uint64          `json:"id"`
+}
+
+// auth - token of API or session of user and the way it's passed
+// to Zabbix, empty token means request without auth
+type auth struct {
+	token string
+	// bearer - pass token in Authorization header instead of
+	// auth field, which is removed in Zabbix 7.0
+	bearer bool
+}
+
+// do - call method of Zabbix JSON-RPC API and decode
+// result into passed value
+func (client *Client) do(
+	ctx context.Context,
+	auth auth,
+	method string,
+	params interface{},
+	result interface{},
+) (err error) {
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues(
+			method,
+			requestResult(err),
+		).Observe(time.Since(start).Seconds())
+	}()
+
+	destiny := karma.Describe(
+		"method", "do",
+	).Describe(
+		"zabbix method", method,
+	)
+
+	payload := Request{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+		ID:      client.nextRequestID(),
+	}
+
+	if !auth.bearer {
+		payload.Auth = auth.token
+	}
+
+	body := new(bytes.Buffer)
+
+	err = json.NewEncoder(body).Encode(payload)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't encode payload for Zabbix",
+		)
+	}
+
+	response, err := post(ctx, client.url, auth, body)
+	if err != nil {
+		return destiny.Describe(
+			"zabbix URL", secrets.Redact(client.url),
+		).Describe(
+			"error", err,
+		).Reason(
+			"can't send request to Zabbix",
+		)
+	}
+
+	defer response.Body.Close()
+
+	answer := Response{}
+
+	err = json.NewDecoder(response.Body).Decode(&answer)
+	if err != nil {
+		return destiny.Describe(
+			"http status", response.StatusCode,
+		).Reason(err)
+	}
+
+	if answer.Error != nil {
+		answer.Error.Method = method
+
+		return destiny.Reason(answer.Error)
+	}
+
+	if answer.ID != payload.ID {
+		return destiny.Describe(
+			"request id", payload.ID,
+		).Describe(
+			"response id", answer.ID,
+		).Reason(
+			"Zabbix answered to another request",
+		)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(answer.Result, result)
+	if err != nil {
+		return destiny.Describe(
+			"error", err,
+		).Reason(
+			"can't decode result from Zabbix",
+		)
+	}
+
+	return nil
+}
+
+func post(
+	ctx context.Context,
+	url string,
+	auth auth,
+	body io.Reader,
+) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		url,
+		body,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set(
+		"Content-Type",
+		"application/json",
+	)
+
+	if auth.bearer && auth.token != "" {
+		request.Header.Set("Authorization", "Bearer "+auth.token)
+	}
+
+	return transport.Client().Do(request)
+}
+
+// requestResult - label of request outcome, Zabbix errors are
+// distinguished from failures of transport
+func requestResult(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	if _, ok := GetError(err); ok {
+		return "zabbix_error"
+	}
+
+	return "error"
+}
@@ -0,0 +1,255 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	karma "github.com/reconquest/karma-go"
+)
+
+// ErrUserNotFound - there is no Zabbix user with passed login or email
+var ErrUserNotFound = errors.New("zabbix user is not found")
+
+// User - user of Zabbix
+type User struct {
+	UserID   string `json:"userid"`
+	Username string `json:"username"`
+	// Alias - login of user before Zabbix 5.4
+	Alias  string   `json:"alias"`
+	Medias []*Media `json:"medias"`
+}
+
+// Media - media of user, sendto is a list of addresses
+// for email and a string for other media types
+type Media struct {
+	SendTo json.RawMessage `json:"sendto"`
+}
+
+// Login - login of user regardless of Zabbix version
+func (user *User) Login() string {
+	if user.Username != "" {
+		return user.Username
+	}
+
+	return user.Alias
+}
+
+// Addresses - addresses of all medias of user
+func (user *User) Addresses() []string {
+	addresses := []string{}
+
+	for _, media := range user.Medias {
+		var list []string
+
+		err := json.Unmarshal(media.SendTo, &list)
+		if err == nil {
+			addresses = append(addresses, list...)
+			continue
+		}
+
+		var single string
+
+		err = json.Unmarshal(media.SendTo, &single)
+		if err == nil {
+			addresses = append(addresses, single)
+		}
+	}
+
+	return addresses
+}
+
+// FindUserByLogin - return user with passed login
+func (client *Client) FindUserByLogin(
+	ctx context.Context,
+	login string,
+) (*User, error) {
+	destiny := karma.Describe(
+		"method", "FindUserByLogin",
+	).Describe(
+		"login", login,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	users := []*User{}
+
+	err = client.Call(
+		ctx,
+		"user.get",
+		map[string]interface{}{
+			"output": []string{"userid", version.userNameField()},
+			"filter": map[string]interface{}{
+				version.userNameField(): []string{login},
+			},
+		},
+		&users,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	if len(users) == 0 {
+		return nil, destiny.Reason(ErrUserNotFound)
+	}
+
+	return users[0], nil
+}
+
+// FindUserByEmail - return user which has media with passed email,
+// medias can't be filtered by user.get, so all users are fetched
+func (client *Client) FindUserByEmail(
+	ctx context.Context,
+	email string,
+) (*User, error) {
+	destiny := karma.Describe(
+		"method", "FindUserByEmail",
+	).Describe(
+		"email", email,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	users := []*User{}
+
+	err = client.Call(
+		ctx,
+		"user.get",
+		map[string]interface{}{
+			"output":       []string{"userid", version.userNameField()},
+			"selectMedias": []string{"sendto"},
+		},
+		&users,
+	)
+	if err != nil {
+		return nil, destiny.Reason(err)
+	}
+
+	for _, user := range users {
+		for _, address := range user.Addresses() {
+			if strings.EqualFold(address, email) {
+				return user, nil
+			}
+		}
+	}
+
+	return nil, destiny.Reason(ErrUserNotFound)
+}
+
+// CreateUserToken - create API token of passed user on behalf of
+// the user of client, token with the same name is replaced.
+// User of client must be allowed to manage tokens of other users.
+func (client *Client) CreateUserToken(
+	ctx context.Context,
+	userID string,
+	name string,
+	expires time.Time,
+) (string, error) {
+	destiny := karma.Describe(
+		"method", "CreateUserToken",
+	).Describe(
+		"userID", userID,
+	)
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	if !version.hasTokens() {
+		return "", destiny.Describe(
+			"zabbix version", version,
+		).Reason(
+			"API tokens are not supported by this version of Zabbix",
+		)
+	}
+
+	tokens := []struct {
+		TokenID string `json:"tokenid"`
+	}{}
+
+	err = client.Call(
+		ctx,
+		"token.get",
+		map[string]interface{}{
+			"output":  []string{"tokenid"},
+			"userids": []string{userID},
+			"filter": map[string]interface{}{
+				"name": name,
+			},
+		},
+		&tokens,
+	)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	if len(tokens) > 0 {
+		tokenIDs := []string{}
+		for _, token := range tokens {
+			tokenIDs = append(tokenIDs, token.TokenID)
+		}
+
+		err = client.Call(ctx, "token.delete", tokenIDs, nil)
+		if err != nil {
+			return "", destiny.Reason(err)
+		}
+	}
+
+	created := struct {
+		TokenIDs []string `json:"tokenids"`
+	}{}
+
+	err = client.Call(
+		ctx,
+		"token.create",
+		map[string]interface{}{
+			"name":       name,
+			"userid":     userID,
+			"expires_at": expires.Unix(),
+		},
+		&created,
+	)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	generated := []struct {
+		TokenID string `json:"tokenid"`
+		Token   string `json:"token"`
+	}{}
+
+	err = client.Call(ctx, "token.generate", created.TokenIDs, &generated)
+	if err != nil {
+		return "", destiny.Reason(err)
+	}
+
+	if len(generated) == 0 {
+		return "", destiny.Reason("Zabbix hasn't generated token")
+	}
+
+	return generated[0].Token, nil
+}
+
+// WithToken - client which calls Zabbix with passed API token,
+// detected version of Zabbix is shared with new client
+func (client *Client) WithToken(token string) *Client {
+	client.versionMutex.Lock()
+	defer client.versionMutex.Unlock()
+
+	return &Client{
+		url:             client.url,
+		token:           token,
+		versionTTL:      client.versionTTL,
+		version:         client.version,
+		versionDeadline: client.versionDeadline,
+	}
+}
@@ -0,0 +1,131 @@
+package zabbix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version - version of Zabbix API which is returned by
+// apiinfo.version, it defines parameters and auth of requests
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion - parse version in format of apiinfo.version
+func ParseVersion(value string) (Version, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return Version{}, fmt.Errorf(
+			"can't parse zabbix version %s",
+			value,
+		)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Version{}, fmt.Errorf(
+			"can't parse zabbix version %s",
+			value,
+		)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Version{}, fmt.Errorf(
+			"can't parse zabbix version %s",
+			value,
+		)
+	}
+
+	return Version{Major: major, Minor: minor}, nil
+}
+
+func (version Version) String() string {
+	return fmt.Sprintf("%d.%d", version.Major, version.Minor)
+}
+
+// AtLeast - whether version is the same or newer than passed
+func (version Version) AtLeast(major int, minor int) bool {
+	if version.Major != major {
+		return version.Major > major
+	}
+
+	return version.Minor >= minor
+}
+
+// usesBearer - auth field is deprecated in Zabbix 6.4 and removed
+// in 7.0, token is passed in Authorization header instead
+func (version Version) usesBearer() bool {
+	return version.AtLeast(6, 4)
+}
+
+// userLoginParam - name of user parameter of user.login,
+// it's renamed in Zabbix 5.4
+func (version Version) userLoginParam() string {
+	if version.AtLeast(5, 4) {
+		return "username"
+	}
+
+	return "user"
+}
+
+// userNameField - name of login field of user object,
+// alias is renamed to username in Zabbix 5.4
+func (version Version) userNameField() string {
+	if version.AtLeast(5, 4) {
+		return "username"
+	}
+
+	return "alias"
+}
+
+// hasTokens - API tokens are introduced in Zabbix 5.4
+func (version Version) hasTokens() bool {
+	return version.AtLeast(5, 4)
+}
+
+// hostGroupsParam - selectGroups of host.get is replaced by
+// selectHostGroups in Zabbix 6.2 and removed in 7.0
+func (version Version) hostGroupsParam() string {
+	if version.AtLeast(6, 2) {
+		return "selectHostGroups"
+	}
+
+	return "selectGroups"
+}
+
+// maintenanceHostsParam - hostids parameter of maintenance.create
+// is replaced by hosts in Zabbix 6.0
+func (version Version) maintenanceHostsParam() string {
+	if version.AtLeast(6, 0) {
+		return "hosts"
+	}
+
+	return "hostids"
+}
+
+// acknowledgeActions - bits of event.acknowledge action which
+// are known by this version of Zabbix
+func (version Version) acknowledgeActions() int {
+	actions := ActionClose |
+		ActionAcknowledge |
+		ActionMessage
+
+	if version.AtLeast(4, 0) {
+		actions |= ActionChangeSeverity
+	}
+
+	if version.AtLeast(5, 0) {
+		actions |= ActionUnacknowledge
+	}
+
+	if version.AtLeast(6, 4) {
+		actions |= ActionSuppress |
+			ActionUnsuppress |
+			ActionChangeRankToCause
+	}
+
+	return actions
+}